@@ -80,6 +80,7 @@ func (ark *arkCore) Ready() {
 		return
 	}
 
+	ark.Basic.initing()
 	ark.Logger.initing()
 	ark.Mutex.initing()
 
@@ -92,6 +93,9 @@ func (ark *arkCore) Ready() {
 	ark.Http.initing()
 	ark.View.initing()
 
+	//都准备好了再开始跑计划
+	ark.Bus.launching()
+
 	ark.readied = true
 }
 func (ark *arkCore) Start() {
@@ -127,13 +131,31 @@ func (ark *arkCore) Stop() {
 	ark.Bus.exiting()
 
 	ark.Mutex.exiting()
+	ark.Basic.exiting()
 	ark.Logger.exiting()
 
 	//同步执行
 	Execute(StopTrigger)
 }
 
+//命令行，配置文件后面跟命令，执行完就退出
+//./app config.toml lang:missing [lang...]
+func (ark *arkCore) command() bool {
+	if len(os.Args) < 3 {
+		return false
+	}
+	switch os.Args[2] {
+	case "lang:missing":
+		ark.Basic.missingCommand(os.Args[3:]...)
+		return true
+	}
+	return false
+}
+
 func (ark *arkCore) Go() {
+	if ark.command() {
+		return
+	}
 	ark.Ready()
 	ark.Start()
 	ark.Waiting()
@@ -1,9 +1,16 @@
 package ark
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
 	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +24,8 @@ type (
 		Mime    string `toml:"mime"`
 		Regular string `toml:"regular"`
 		Lang    string `toml:"lang"`
+		//是否收集缺失的语言字串，开发模式下默认收集
+		Missing bool `toml:"missing"`
 	}
 
 	//语言配置
@@ -27,14 +36,26 @@ type (
 	}
 
 	basicModule struct {
-		mutex sync.Mutex
+		mutex sync.RWMutex
 
 		states   map[string]State
 		langs    map[string]string
 		mimes    map[string]Mime
+		mimexts  map[string]string
 		regulars map[string]Regular
 		types    map[string]Type
 		cryptos  map[string]Crypto
+
+		//mime注册的顺序，重建mimexts用
+		mimeorder []string
+
+		//语言包文件的修改时间，用于热重载
+		langtimes map[string]time.Time
+		//语言包文件中的字串，重载时删除文件中已经去掉的
+		langfiles map[string]map[string]bool
+		//缺失的语言字串，lang -> key -> 次数
+		missings map[string]map[string]int64
+		watching chan bool
 	}
 
 	State struct {
@@ -42,8 +63,16 @@ type (
 		Desc   string   `json:"desc"`
 		Alias  []string `json:"alias"`
 		Code   int      `json:"code"`
+		Status int      `json:"status"` //对应的HTTP状态，0表示按成功失败默认处理
 		String string   `json:"string"`
 	}
+	//状态目录，导出给客户端SDK使用
+	Catalog struct {
+		Code   int               `json:"code"`
+		State  string            `json:"state"`
+		Status int               `json:"status"`
+		Texts  map[string]string `json:"texts"`
+	}
 	Mime struct {
 		Name  string   `json:"name"`
 		Desc  string   `json:"desc"`
@@ -82,15 +111,66 @@ type (
 	}
 )
 
+const (
+	basicMissingLimit = 1000
+)
+
+//语言字串名，字母数字下划线，可以用点和横线分隔
+var langKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+([.\-][A-Za-z0-9_]+)*$`)
+
+//同一种容器格式的类型，嗅探结果是其中之一时，客户端的更具体的类型也认为可信
+var sniffFamilies = map[string]string{
+	"text/xml": "xml", "application/xml": "xml",
+	"video/mp4": "mp4", "audio/mp4": "mp4", "audio/x-m4a": "mp4", "audio/m4a": "mp4", "video/x-m4v": "mp4",
+	"application/ogg": "ogg", "audio/ogg": "ogg", "video/ogg": "ogg",
+	"video/webm": "webm", "audio/webm": "webm",
+}
+
+//会被浏览器执行的类型，只能由嗅探得出，不能从扩展名或客户端类型升级过来
+var sniffActives = map[string]bool{
+	"text/html": true, "application/xhtml+xml": true, "image/svg+xml": true,
+	"text/javascript": true, "application/javascript": true, "application/x-javascript": true, "application/ecmascript": true,
+	"text/xml": true, "application/xml": true, "text/xsl": true, "application/xslt+xml": true,
+}
+
+//zip容器格式的具体类型，嗅探出zip时扩展名是这些类型才可信
+var sniffZips = map[string]bool{
+	"application/zip": true, "application/x-zip-compressed": true,
+	"application/java-archive": true, "application/vnd.android.package-archive": true,
+	"application/epub+zip": true, "application/vnd.ms-xpsdocument": true,
+}
+
+//标准库http.DetectContentType不支持的文件头
+var sniffSignatures = []struct {
+	offset int
+	magic  []byte
+	mime   string
+}{
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\xFD7zXZ\x00"), "application/x-xz"},
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("8BPS"), "image/vnd.adobe.photoshop"},
+	{4, []byte("ftypheic"), "image/heic"},
+	{4, []byte("ftypqt  "), "video/quicktime"},
+}
+
 func newBasic() *basicModule {
 
 	basic := &basicModule{
 		states:   make(map[string]State, 0),
 		mimes:    make(map[string]Mime, 0),
+		mimexts:  make(map[string]string, 0),
 		regulars: make(map[string]Regular, 0),
 		langs:    make(map[string]string, 0),
 		types:    make(map[string]Type, 0),
 		cryptos:  make(map[string]Crypto, 0),
+
+		langtimes: make(map[string]time.Time, 0),
+		langfiles: make(map[string]map[string]bool, 0),
+		missings:  make(map[string]map[string]int64, 0),
 	}
 
 	//这里加载语言文件，和其它定义
@@ -106,8 +186,9 @@ func newBasic() *basicModule {
 	// }
 
 	//加载mime类型
+	//要传指针，要不然解析不到数据
 	var mimes map[string][]string
-	err := loading(path.Join(ark.Config.Basic.Mime), mimes)
+	err := loading(path.Join(ark.Config.Basic.Mime), &mimes)
 	if err == nil {
 		for key, vals := range mimes {
 			basic.Mime(key, Mime{Types: vals})
@@ -116,7 +197,7 @@ func newBasic() *basicModule {
 
 	//加载正则表达式
 	var regulars map[string][]string
-	err = loading(path.Join(ark.Config.Basic.Regular), regulars)
+	err = loading(path.Join(ark.Config.Basic.Regular), &regulars)
 	if err == nil {
 		for key, vals := range regulars {
 			basic.Regular(key, Regular{Expressions: vals})
@@ -124,20 +205,106 @@ func newBasic() *basicModule {
 	}
 
 	//加载语言包
-	var langs map[string]string
-	err = loading(path.Join(ark.Config.Basic.Lang, DEFAULT+".toml"), langs)
-	if err == nil {
-		basic.Lang(DEFAULT, langs)
+	basic.langing()
+
+	return basic
+}
+
+//初始化，开发模式下监控语言包文件
+func (module *basicModule) initing() {
+	if Mode == Developing && module.watching == nil {
+		module.watching = make(chan bool)
+		go module.watcher()
+	}
+}
+
+//退出
+func (module *basicModule) exiting() {
+	if module.watching != nil {
+		close(module.watching)
+		module.watching = nil
+	}
+
+	//把缺失的语言字串导出，方便翻译补全
+	if module.collecting() {
+		module.dumping()
+	}
+}
+
+//加载语言包，只加载有变化的文件，返回重载了的语言
+func (module *basicModule) langing() []string {
+	langs := []string{DEFAULT}
+	for lang := range ark.Config.Lang {
+		langs = append(langs, lang)
 	}
-	for lang, _ := range ark.Config.Lang {
-		var langs map[string]string
-		err := loading(path.Join(ark.Config.Basic.Lang, lang+".toml"), langs)
-		if err == nil {
-			basic.Lang(lang, langs)
+
+	reloads := []string{}
+	for _, lang := range langs {
+		file := path.Join(ark.Config.Basic.Lang, lang+".toml")
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+
+		module.mutex.Lock()
+		last, ok := module.langtimes[lang]
+		module.mutex.Unlock()
+		if ok && last.Equal(stat.ModTime()) {
+			continue
+		}
+
+		var texts map[string]string
+		err = loading(file, &texts)
+		if err != nil {
+			continue
+		}
+		module.Lang(lang, texts)
+
+		module.mutex.Lock()
+		module.langtimes[lang] = stat.ModTime()
+		//文件中删掉的字串也要去掉，代码中注册的不在文件里，不受影响
+		keys := make(map[string]bool, len(texts))
+		for key := range texts {
+			keys[key] = true
+		}
+		for key := range module.langfiles[lang] {
+			if keys[key] == false {
+				delete(module.langs, fmt.Sprintf("%v.%v", lang, key))
+			}
+		}
+		module.langfiles[lang] = keys
+		//重新加载后，之前缺失的字串可能已经补上了
+		if ok {
+			for key := range texts {
+				delete(module.missings[lang], key)
+			}
+		}
+		module.mutex.Unlock()
+
+		if ok {
+			reloads = append(reloads, lang)
 		}
 	}
 
-	return basic
+	return reloads
+}
+
+//轮询语言包目录，文件有修改就重载
+func (module *basicModule) watcher() {
+	stop := module.watching
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, lang := range module.langing() {
+				ark.Logger.Logger("basic").Debug("[基础]重载语言包", "lang", lang)
+			}
+		}
+	}
 }
 
 // func (module *basicModule) State(config map[string]int, overrides ...bool) {
@@ -193,6 +360,9 @@ func (module *basicModule) State(name string, config State, overrides ...bool) {
 }
 
 func (module *basicModule) Code(state string, defs ...int) int {
+	module.mutex.RLock()
+	defer module.mutex.RUnlock()
+
 	if state, ok := module.states[state]; ok {
 		return state.Code
 	}
@@ -202,6 +372,63 @@ func (module *basicModule) Code(state string, defs ...int) int {
 	return -1
 }
 
+//状态对应的HTTP状态
+func (module *basicModule) Status(state string, defs ...int) int {
+	module.mutex.RLock()
+	defer module.mutex.RUnlock()
+
+	if state, ok := module.states[state]; ok && state.Status > 0 {
+		return state.Status
+	}
+	if len(defs) > 0 {
+		return defs[0]
+	}
+	return 0
+}
+
+//设置状态对应的HTTP状态
+func (module *basicModule) StateStatus(name string, status int) {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	if state, ok := module.states[name]; ok {
+		state.Status = status
+		module.states[name] = state
+	}
+}
+
+//状态目录，包括状态码，HTTP状态，和所有语言的文字
+func (module *basicModule) Catalogs() []Catalog {
+	langs := []string{DEFAULT}
+	for lang := range ark.Config.Lang {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs[1:])
+
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	catalogs := []Catalog{}
+	for key, state := range module.states {
+		texts := map[string]string{}
+		for _, lang := range langs {
+			texts[lang] = module.lookup(lang, key)
+		}
+		catalogs = append(catalogs, Catalog{
+			Code: state.Code, State: key, Status: state.Status, Texts: texts,
+		})
+	}
+
+	sort.Slice(catalogs, func(i, j int) bool {
+		if catalogs[i].Code == catalogs[j].Code {
+			return catalogs[i].State < catalogs[j].State
+		}
+		return catalogs[i].Code > catalogs[j].Code
+	})
+
+	return catalogs
+}
+
 func (module *basicModule) Results(langs ...string) map[int]string {
 	lang := DEFAULT
 	if len(langs) > 0 {
@@ -248,6 +475,39 @@ func (module *basicModule) Mime(name string, config Mime, overrides ...bool) {
 			}
 		}
 	}
+
+	//记录mime对应的首选扩展名，先注册的优先，这样反查扩展名是固定的
+	if name != "" {
+		exists := false
+		for _, ext := range module.mimeorder {
+			if ext == name {
+				exists = true
+				break
+			}
+		}
+		if exists == false {
+			module.mimeorder = append(module.mimeorder, name)
+		}
+		module.mimexting()
+	}
+}
+
+//按注册顺序重建mime到扩展名的反查表，和实际生效的注册保持一致，调用的时候已经加锁
+func (module *basicModule) mimexting() {
+	mimexts := make(map[string]string, len(module.mimexts))
+	for _, ext := range module.mimeorder {
+		config, ok := module.mimes[ext]
+		if ok == false {
+			continue
+		}
+		for _, tttt := range config.Types {
+			tttt = strings.ToLower(tttt)
+			if _, ok := mimexts[tttt]; ok == false {
+				mimexts[tttt] = ext
+			}
+		}
+	}
+	module.mimexts = mimexts
 }
 
 // func (module *basicModule) Mime(config map[string][]string, overrides ...bool) {
@@ -275,6 +535,13 @@ func (module *basicModule) Extension(mime string, defs ...string) string {
 	if strings.Contains(mime, "/") == false {
 		return mime
 	}
+	//去掉 ; charset=utf-8 之类的参数
+	if i := strings.Index(mime, ";"); i > 0 {
+		mime = strings.TrimSpace(mime[:i])
+	}
+	if ext, ok := module.mimexts[strings.ToLower(mime)]; ok {
+		return ext
+	}
 	for ext, config := range module.mimes {
 		for _, v := range config.Types {
 			if strings.ToLower(v) == strings.ToLower(mime) {
@@ -312,6 +579,116 @@ func (module *basicModule) Mimetype(ext string, defs ...string) string {
 	return "application/octet-stream"
 }
 
+//按文件头的魔数嗅探mime类型，最多读取512字节
+//如果reader可以Seek，读完会重新定位到开头
+func (module *basicModule) Sniff(reader io.Reader) string {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "application/octet-stream"
+	}
+	head = head[:n]
+
+	if seeker, ok := reader.(io.Seeker); ok {
+		seeker.Seek(0, io.SeekStart)
+	}
+
+	//标准库不支持的一些格式
+	for _, sign := range sniffSignatures {
+		if len(head) >= sign.offset+len(sign.magic) && bytes.Equal(head[sign.offset:sign.offset+len(sign.magic)], sign.magic) {
+			return sign.mime
+		}
+	}
+
+	mime := http.DetectContentType(head)
+	if i := strings.Index(mime, ";"); i > 0 {
+		mime = strings.TrimSpace(mime[:i])
+	}
+	return mime
+}
+
+//综合嗅探结果，扩展名和客户端提供的类型，得到可信的mime类型和扩展名
+//嗅探结果是通用类型的时候（文本，压缩包等容器格式），以扩展名为准
+func (module *basicModule) sniffing(sniff, ext, declared string) (string, string) {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+
+	switch sniff {
+	case "", "application/octet-stream", "text/plain", "application/zip", "application/x-gzip":
+		if ext != "" {
+			if mime := strings.ToLower(module.Mimetype(ext, "")); mime != "" && sniffUpgrade(sniff, mime) {
+				return mime, ext
+			}
+		}
+		if sniff == "" || sniff == "application/octet-stream" {
+			if declared != "" && sniffUpgrade(sniff, strings.ToLower(mimeBase(declared))) {
+				return declared, module.Extension(declared, ext)
+			}
+			//扩展名是认得的类型但对不上内容，就不能保留了
+			if ext != "" && module.Mimetype(ext, "") != "" {
+				ext = module.Extension("application/octet-stream", "bin")
+			}
+			return "application/octet-stream", ext
+		}
+		return sniff, module.Extension(sniff)
+	}
+
+	//扩展名对应的类型和嗅探的是同一类，保留原扩展名和更具体的类型
+	//比如jpeg和jpg，svg嗅探出来是xml，m4a嗅探出来是mp4
+	if ext != "" {
+		if mime := strings.ToLower(module.Mimetype(ext, "")); mime != "" && sniffFamily(mime) == sniffFamily(sniff) {
+			return mime, ext
+		}
+	}
+	if ext == "" && declared != "" {
+		if mime := strings.ToLower(mimeBase(declared)); sniffFamily(mime) == sniffFamily(sniff) {
+			return mime, module.Extension(mime, module.Extension(sniff))
+		}
+	}
+
+	return sniff, module.Extension(sniff, ext)
+}
+
+//去掉mime的参数
+func mimeBase(mime string) string {
+	if i := strings.Index(mime, ";"); i > 0 {
+		mime = mime[:i]
+	}
+	return strings.TrimSpace(mime)
+}
+
+//嗅探结果是通用类型时，扩展名或客户端提供的类型是否可以替代它
+//只能升级到同一类的具体类型，会被执行的类型一律不行
+func sniffUpgrade(sniff, mime string) bool {
+	if sniffActives[mime] {
+		return false
+	}
+	switch sniff {
+	case "text/plain":
+		return strings.HasPrefix(mime, "text/") || mime == "application/json" || strings.HasSuffix(mime, "+json") ||
+			mime == "application/x-yaml" || mime == "application/yaml" || mime == "application/toml"
+	case "application/zip":
+		return sniffZips[mime] || strings.HasSuffix(mime, "+zip") ||
+			strings.HasPrefix(mime, "application/vnd.openxmlformats-officedocument.") ||
+			strings.HasPrefix(mime, "application/vnd.oasis.opendocument.")
+	case "application/x-gzip":
+		return strings.Contains(mime, "gzip") || mime == "application/x-compressed-tar"
+	}
+	//认不出来的二进制，文本类的肯定不对
+	return !strings.HasPrefix(mime, "text/")
+}
+
+//嗅探只能认出容器格式的，归到同一类
+func sniffFamily(mime string) string {
+	mime = mimeBase(mime)
+	if vv, ok := sniffFamilies[mime]; ok {
+		return vv
+	}
+	if strings.HasSuffix(mime, "+xml") {
+		return "xml"
+	}
+	return mime
+}
+
 // func (module *basicModule) Regular(config map[string][]string, overrides ...bool) {
 // 	module.mutex.Lock()
 // 	defer module.mutex.Unlock()
@@ -414,18 +791,15 @@ func (module *basicModule) String(lang, name string, args ...Any) string {
 		lang = DEFAULT
 	}
 
-	defaultKey := fmt.Sprintf("%v.%v", DEFAULT, name)
 	langKey := fmt.Sprintf("%v.%v", lang, name)
+	if vv, ok := module.langs[langKey]; ok == false || vv == "" {
+		module.missed(lang, name)
+	}
 
-	langStr := ""
+	langStr := module.lookup(lang, name)
 
-	if vv, ok := module.langs[langKey]; ok && vv != "" {
-		langStr = vv
-	} else if vv, ok := module.langs[defaultKey]; ok && vv != "" {
-		langStr = vv
-	} else {
-		langStr = name
-	}
+	//结果附带的底层错误，不参与格式化，也不输出给客户端
+	args = uncaused(args)
 
 	if len(args) > 0 {
 		ccc := strings.Count(langStr, "%") - strings.Count(langStr, "%%")
@@ -436,6 +810,199 @@ func (module *basicModule) String(lang, name string, args ...Any) string {
 	return langStr
 }
 
+//查找语言字串，找不到用默认语言，调用的时候已经加锁
+func (module *basicModule) lookup(lang, name string) string {
+	if vv, ok := module.langs[fmt.Sprintf("%v.%v", lang, name)]; ok && vv != "" {
+		return vv
+	}
+	if vv, ok := module.langs[fmt.Sprintf("%v.%v", DEFAULT, name)]; ok && vv != "" {
+		return vv
+	}
+	return name
+}
+
+func (module *basicModule) collecting() bool {
+	return ark.Config.Basic.Missing || Mode == Developing
+}
+
+//记录缺失的语言字串，调用的时候已经加锁
+//只记录像字串名的，错误信息之类的自由文本不记，每个语言最多记录basicMissingLimit个
+func (module *basicModule) missed(lang, name string) {
+	if name == "" || len(name) > 100 || module.collecting() == false {
+		return
+	}
+	if langKeyRegexp.MatchString(name) == false {
+		return
+	}
+	if _, ok := module.missings[lang]; ok == false {
+		module.missings[lang] = make(map[string]int64)
+	}
+	if _, ok := module.missings[lang][name]; ok == false && len(module.missings[lang]) >= basicMissingLimit {
+		return
+	}
+	module.missings[lang][name]++
+}
+
+//获取缺失的语言字串，不指定语言就返回全部
+func (module *basicModule) Missing(langs ...string) map[string][]string {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	filters := map[string]bool{}
+	for _, lang := range langs {
+		filters[lang] = true
+	}
+
+	missings := map[string][]string{}
+	for lang, keys := range module.missings {
+		if len(filters) > 0 && filters[lang] == false {
+			continue
+		}
+		names := []string{}
+		for key := range keys {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			missings[lang] = names
+		}
+	}
+	return missings
+}
+
+//导出缺失的语言字串，每个语言一个toml文件，值为默认语言的字串，方便翻译
+//目录中已有的会合并，已经补上的去掉，多次运行的结果可以累积
+func (module *basicModule) Dump(dir string) error {
+	missings := module.Missing()
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	langs := map[string]bool{}
+	for lang := range missings {
+		langs[lang] = true
+	}
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".toml") {
+			langs[strings.TrimSuffix(file.Name(), ".toml")] = true
+		}
+	}
+
+	for lang := range langs {
+		file := path.Join(dir, lang+".toml")
+
+		keys := map[string]bool{}
+		var olds map[string]string
+		if loading(file, &olds) == nil {
+			for key := range olds {
+				keys[key] = true
+			}
+		}
+		for _, key := range missings[lang] {
+			keys[key] = true
+		}
+
+		names := []string{}
+		module.mutex.Lock()
+		for key := range keys {
+			if vv, ok := module.langs[fmt.Sprintf("%v.%v", lang, key)]; ok == false || vv == "" {
+				names = append(names, key)
+			}
+		}
+		module.mutex.Unlock()
+
+		if len(names) == 0 {
+			os.Remove(file)
+			continue
+		}
+		sort.Strings(names)
+
+		err := ioutil.WriteFile(file, []byte(module.dumpText(names)), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//导出的toml内容
+func (module *basicModule) dumpText(keys []string) string {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	lines := []string{}
+	for _, key := range keys {
+		text := module.langs[fmt.Sprintf("%v.%v", DEFAULT, key)]
+		lines = append(lines, fmt.Sprintf("%s = %s", strconv.Quote(key), strconv.Quote(text)))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+//命令行导出缺失的语言字串，输出到控制台
+//包括运行时收集导出到missing目录的，和默认语言有、其它语言没有的
+//./app config.toml lang:missing [lang...]
+func (module *basicModule) missingCommand(langs ...string) {
+	if len(langs) == 0 {
+		for lang := range ark.Config.Lang {
+			if lang != DEFAULT {
+				langs = append(langs, lang)
+			}
+		}
+	}
+	sort.Strings(langs)
+
+	for _, lang := range langs {
+		keys := map[string]bool{}
+
+		var dumps map[string]string
+		if loading(path.Join(ark.Config.Basic.Lang, "missing", lang+".toml"), &dumps) == nil {
+			for key := range dumps {
+				keys[key] = true
+			}
+		}
+
+		module.mutex.Lock()
+		for key := range module.langfiles[DEFAULT] {
+			keys[key] = true
+		}
+		for key := range module.missings[lang] {
+			keys[key] = true
+		}
+		names := []string{}
+		for key := range keys {
+			if vv, ok := module.langs[fmt.Sprintf("%v.%v", lang, key)]; ok == false || vv == "" {
+				names = append(names, key)
+			}
+		}
+		module.mutex.Unlock()
+
+		sort.Strings(names)
+		fmt.Printf("# %s %d\n", lang, len(names))
+		if len(names) > 0 {
+			fmt.Print(module.dumpText(names))
+		}
+	}
+}
+
+//退出时输出到控制台，并导出到语言包目录下的missing目录
+func (module *basicModule) dumping() {
+	missings := module.Missing()
+	for lang, keys := range missings {
+		ark.Logger.output("[基础]缺失语言字串", lang, len(keys), strings.Join(keys, ", "))
+	}
+
+	if len(missings) > 0 {
+		dir := path.Join(ark.Config.Basic.Lang, "missing")
+		if err := module.Dump(dir); err != nil {
+			ark.Logger.output("[基础]导出缺失语言字串失败", err)
+		}
+	}
+}
+
 func (module *basicModule) Type(name string, config Type, overrides ...bool) {
 	module.mutex.Lock()
 	defer module.mutex.Unlock()
@@ -1453,6 +2020,9 @@ func Code(name string, defs ...int) int {
 
 // 	ark.Basic.Mime(ms, overrides...)
 // }
+func Sniff(reader io.Reader) string {
+	return ark.Basic.Sniff(reader)
+}
 func Mimetype(ext string, defs ...string) string {
 	return ark.Basic.Mimetype(ext, defs...)
 }
@@ -1487,6 +2057,31 @@ func Results(langs ...string) map[int]string {
 	return ark.Basic.Results(langs...)
 }
 
+//状态目录
+func Catalogs() []Catalog {
+	return ark.Basic.Catalogs()
+}
+
+//状态目录JSON，给客户端SDK生成代码用
+func CatalogJson() ([]byte, error) {
+	return ark.Codec.Marshal(ark.Basic.Catalogs())
+}
+
+//缺失的语言字串
+func LangMissing(langs ...string) map[string][]string {
+	return ark.Basic.Missing(langs...)
+}
+
+//导出缺失的语言字串到目录
+func LangDump(dir string) error {
+	return ark.Basic.Dump(dir)
+}
+
+//命令行导出缺失的语言字串
+func LangMissingCommand(langs ...string) {
+	ark.Basic.missingCommand(langs...)
+}
+
 //---------------------- mapping --------------------------
 // func Type(name string, config Map, overrides ...bool) {
 // 	ark.Basic.Type(name, config, overrides...)
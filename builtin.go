@@ -8,9 +8,96 @@ import (
 )
 
 func builtin() {
+	built_driver()
+	built_mime()
 	built_router()
 }
 
+//内置驱动，不覆盖外部注册的同名驱动
+func built_driver() {
+	ark.Logger.Driver("file", &fileLoggerDriver{}, false)
+	ark.Bus.Driver(DEFAULT, &memoryBusDriver{}, false)
+	ark.Bus.Driver("memory", &memoryBusDriver{}, false)
+	ark.Bus.Driver("file", &fileBusDriver{}, false)
+	ark.Mutex.Driver(DEFAULT, &memoryMutexDriver{}, false)
+	ark.Mutex.Driver("memory", &memoryMutexDriver{}, false)
+	ark.Mutex.Driver("file", &fileMutexDriver{}, false)
+}
+
+//内置的默认mime类型，不覆盖配置文件中的定义
+//同一mime有多个扩展名的，排在前面的做为首选扩展名
+func built_mime() {
+	mimes := []struct {
+		ext   string
+		types []string
+	}{
+		{"txt", []string{"text/plain"}},
+		{"html", []string{"text/html"}},
+		{"htm", []string{"text/html"}},
+		{"css", []string{"text/css"}},
+		{"csv", []string{"text/csv"}},
+		{"md", []string{"text/markdown"}},
+		{"xml", []string{"text/xml", "application/xml"}},
+		{"js", []string{"application/javascript", "text/javascript"}},
+		{"json", []string{"application/json", "text/json"}},
+		{"pdf", []string{"application/pdf"}},
+		{"rtf", []string{"application/rtf", "text/rtf"}},
+		{"doc", []string{"application/msword"}},
+		{"docx", []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"}},
+		{"xls", []string{"application/vnd.ms-excel"}},
+		{"xlsx", []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}},
+		{"ppt", []string{"application/vnd.ms-powerpoint"}},
+		{"pptx", []string{"application/vnd.openxmlformats-officedocument.presentationml.presentation"}},
+		{"zip", []string{"application/zip", "application/x-zip-compressed"}},
+		{"rar", []string{"application/x-rar-compressed", "application/vnd.rar"}},
+		{"7z", []string{"application/x-7z-compressed"}},
+		{"gz", []string{"application/gzip", "application/x-gzip"}},
+		{"bz2", []string{"application/x-bzip2"}},
+		{"xz", []string{"application/x-xz"}},
+		{"tar", []string{"application/x-tar"}},
+		{"apk", []string{"application/vnd.android.package-archive"}},
+		{"wasm", []string{"application/wasm"}},
+		{"jpg", []string{"image/jpeg"}},
+		{"jpeg", []string{"image/jpeg"}},
+		{"png", []string{"image/png"}},
+		{"gif", []string{"image/gif"}},
+		{"bmp", []string{"image/bmp"}},
+		{"webp", []string{"image/webp"}},
+		{"ico", []string{"image/x-icon", "image/vnd.microsoft.icon"}},
+		{"svg", []string{"image/svg+xml"}},
+		{"tif", []string{"image/tiff"}},
+		{"tiff", []string{"image/tiff"}},
+		{"heic", []string{"image/heic"}},
+		{"psd", []string{"image/vnd.adobe.photoshop"}},
+		{"mp3", []string{"audio/mpeg"}},
+		{"wav", []string{"audio/wav", "audio/x-wav", "audio/wave"}},
+		{"wma", []string{"audio/x-ms-wma"}},
+		{"ogg", []string{"audio/ogg", "application/ogg"}},
+		{"flac", []string{"audio/flac"}},
+		{"aac", []string{"audio/aac"}},
+		{"mid", []string{"audio/midi"}},
+		{"mp4", []string{"video/mp4"}},
+		{"m4a", []string{"audio/mp4"}},
+		{"webm", []string{"video/webm"}},
+		{"avi", []string{"video/avi", "video/x-msvideo"}},
+		{"mov", []string{"video/quicktime"}},
+		{"mkv", []string{"video/x-matroska"}},
+		{"wmv", []string{"video/x-ms-wmv"}},
+		{"mpeg", []string{"video/mpeg"}},
+		{"ts", []string{"video/mp2t"}},
+		{"flv", []string{"video/x-flv"}},
+		{"woff", []string{"font/woff"}},
+		{"woff2", []string{"font/woff2"}},
+		{"ttf", []string{"font/ttf"}},
+		{"otf", []string{"font/otf"}},
+		{"eot", []string{"application/vnd.ms-fontobject"}},
+	}
+
+	for _, mime := range mimes {
+		ark.Basic.Mime(mime.ext, Mime{Types: mime.types}, false)
+	}
+}
+
 func built_router() {
 
 	browse := ark.Config.File.Site + "." + "browse"
@@ -0,0 +1,421 @@
+package ark
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//计划的时间表达式
+//支持6段带秒的 "秒 分 时 日 月 周"，和5段的 "分 时 日 月 周"，秒为0
+//每段支持 * ? a a-b */n a-b/n a/n 和逗号分隔的列表，月和周可以用英文缩写
+//日和周都不是*的时候，满足其一就可以，和crontab一样
+//还支持 @yearly @monthly @weekly @daily @hourly 和 @every 1h30m
+//按墙上时间匹配，夏令时跳过的时间不触发，重复的一小时内只有每小时都触发的计划会再触发一次
+
+const (
+	//每个小时都有的位图
+	cronHourly = 1<<24 - 1
+	//找真实时间时，前后各看一下时区偏移
+	cronProbe = time.Hour * 48
+	//跳过不存在的时间最多找这么多次，不会死循环
+	cronAttempts = 1000
+)
+
+type (
+	cronSchedule struct {
+		expr string
+
+		second, minute, hour, dom, month, dow uint64
+		domStar, dowStar                      bool
+
+		every time.Duration
+	}
+
+	cronBounds struct {
+		min, max int
+		names    map[string]int
+	}
+)
+
+var (
+	cronSeconds = cronBounds{0, 59, nil}
+	cronMinutes = cronBounds{0, 59, nil}
+	cronHours   = cronBounds{0, 23, nil}
+	cronDoms    = cronBounds{1, 31, nil}
+	cronMonths  = cronBounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDows = cronBounds{0, 6, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly": "0 0 0 1 1 *", "@annually": "0 0 0 1 1 *",
+		"@monthly": "0 0 0 1 * *", "@weekly": "0 0 0 * * 0",
+		"@daily": "0 0 0 * * *", "@midnight": "0 0 0 * * *",
+		"@hourly": "0 0 * * * *",
+	}
+)
+
+//解析时间表达式
+func cronParse(expr string) (*cronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if spec == "" {
+		return nil, errors.New("空的时间表达式")
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+		if every < time.Second {
+			return nil, errors.New("间隔不能小于1秒：" + expr)
+		}
+		return &cronSchedule{expr: expr, every: every}, nil
+	}
+	if vv, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = vv
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("无效的时间表达式：" + expr)
+	}
+
+	schedule := &cronSchedule{expr: expr}
+	targets := []*uint64{
+		&schedule.second, &schedule.minute, &schedule.hour,
+		&schedule.dom, &schedule.month, &schedule.dow,
+	}
+	bounds := []cronBounds{cronSeconds, cronMinutes, cronHours, cronDoms, cronMonths, cronDows}
+	for i, field := range fields {
+		bits, err := cronField(field, bounds[i])
+		if err != nil {
+			return nil, errors.New("无效的时间表达式：" + expr + "，" + err.Error())
+		}
+		*targets[i] = bits
+	}
+	schedule.domStar = fields[3] == "*" || fields[3] == "?"
+	schedule.dowStar = fields[5] == "*" || fields[5] == "?"
+
+	//周日可以写成7
+	if schedule.dow&(1<<7) > 0 {
+		schedule.dow |= 1
+	}
+
+	return schedule, nil
+}
+
+//解析一段，返回位图
+func cronField(field string, bounds cronBounds) (uint64, error) {
+	bits := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if pos := strings.Index(part, "/"); pos >= 0 {
+			n, err := strconv.Atoi(part[pos+1:])
+			if err != nil || n <= 0 {
+				return 0, errors.New("无效的步长 " + part)
+			}
+			step = n
+			part = part[:pos]
+		}
+
+		min, max := bounds.min, bounds.max
+		if part == "*" || part == "?" {
+		} else if pos := strings.Index(part, "-"); pos > 0 {
+			a, err := cronValue(part[:pos], bounds)
+			if err != nil {
+				return 0, err
+			}
+			b, err := cronValue(part[pos+1:], bounds)
+			if err != nil {
+				return 0, err
+			}
+			min, max = a, b
+		} else {
+			a, err := cronValue(part, bounds)
+			if err != nil {
+				return 0, err
+			}
+			min = a
+			//a/n 表示从a开始每n个，单独的a只有一个
+			if step == 1 {
+				max = a
+			}
+		}
+		if min > max {
+			return 0, errors.New("无效的范围 " + part)
+		}
+
+		for i := min; i <= max; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(text string, bounds cronBounds) (int, error) {
+	if vv, ok := bounds.names[strings.ToLower(text)]; ok {
+		return vv, nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, errors.New("无效的值 " + text)
+	}
+	//周日可以写成7
+	max := bounds.max
+	if bounds.names != nil && bounds.max == 6 {
+		max = 7
+	}
+	if n < bounds.min || n > max {
+		return 0, errors.New("超出范围的值 " + text)
+	}
+	return n, nil
+}
+
+func (schedule *cronSchedule) dayMatch(t time.Time) bool {
+	domMatch := schedule.dom&(1<<uint(t.Day())) > 0
+	dowMatch := schedule.dow&(1<<uint(t.Weekday())) > 0
+	if schedule.domStar || schedule.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+//墙上时间，用UTC表示，UTC没有夏令时，按字段找的时候不会跳来跳去
+func cronCivil(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+//墙上时间对应的真实时间，用时区偏移直接减出来
+//夏令时跳过的时间没有，重复的一小时有两个
+//只有每小时都触发的计划两个都要，其它的只在第一次触发，和crontab一样不会重复执行
+func (schedule *cronSchedule) instants(civil time.Time, loc *time.Location) []time.Time {
+	offsets := map[int]bool{}
+	for _, probe := range []time.Duration{-cronProbe, 0, cronProbe} {
+		_, offset := civil.Add(probe).In(loc).Zone()
+		offsets[offset] = true
+	}
+
+	instants := []time.Time{}
+	for offset := range offsets {
+		at := civil.Add(-time.Duration(offset) * time.Second).In(loc)
+		if cronCivil(at).Equal(civil) {
+			instants = append(instants, at)
+		}
+	}
+	sort.Slice(instants, func(i, j int) bool {
+		return instants[i].Before(instants[j])
+	})
+
+	if len(instants) > 1 && schedule.hour&cronHourly != cronHourly {
+		instants = instants[:1]
+	}
+	return instants
+}
+
+//t前后用到的时区偏移，t自己的排在前面
+func cronOffsets(t time.Time, loc *time.Location) []int {
+	offsets := []int{}
+	for _, probe := range []time.Duration{0, -cronProbe, cronProbe} {
+		_, offset := t.Add(probe).In(loc).Zone()
+		exists := false
+		for _, vv := range offsets {
+			if vv == offset {
+				exists = true
+			}
+		}
+		if !exists {
+			offsets = append(offsets, offset)
+		}
+	}
+	return offsets
+}
+
+// Next t之后的下一次时间，找不到返回零值
+//按墙上时间匹配，夏令时跳过的时间不触发
+//往回拨的时候，墙上时间会再走一遍，所以每个时区偏移下的墙上时间都找一遍，取最早的
+func (schedule *cronSchedule) Next(t time.Time) time.Time {
+	if schedule.every > 0 {
+		return t.Truncate(time.Second).Add(schedule.every)
+	}
+
+	loc := t.Location()
+	t = t.Truncate(time.Second)
+
+	best := time.Time{}
+	for _, offset := range cronOffsets(t, loc) {
+		civil := cronCivil(t.Add(time.Second).In(time.FixedZone("", offset)))
+		if at := schedule.forward(civil, loc, t, best); !at.IsZero() {
+			best = at
+		}
+	}
+	return best
+}
+
+// Prev t之前的上一次时间，不包括t，找不到返回零值
+func (schedule *cronSchedule) Prev(t time.Time) time.Time {
+	if schedule.every > 0 {
+		return t.Truncate(time.Second).Add(-schedule.every)
+	}
+
+	loc := t.Location()
+	from := t.Truncate(time.Second)
+	if from.Equal(t) {
+		from = from.Add(-time.Second)
+	}
+
+	best := time.Time{}
+	for _, offset := range cronOffsets(t, loc) {
+		civil := cronCivil(from.In(time.FixedZone("", offset)))
+		if at := schedule.backward(civil, loc, t, best); !at.IsZero() {
+			best = at
+		}
+	}
+	return best
+}
+
+//从墙上时间civil往后找第一个比t晚的真实时间，不比bound早的不要
+func (schedule *cronSchedule) forward(civil time.Time, loc *time.Location, t, bound time.Time) time.Time {
+	limit := civil.Year() + 5
+	for i := 0; i < cronAttempts; i++ {
+		civil = schedule.after(civil, limit)
+		if civil.IsZero() {
+			return time.Time{}
+		}
+		for _, at := range schedule.instants(civil, loc) {
+			if !bound.IsZero() && !at.Before(bound) {
+				return time.Time{}
+			}
+			if at.After(t) {
+				return at
+			}
+		}
+		//跳过的时间和重复的一小时都是整分钟的，从下一分钟接着找
+		civil = civil.Truncate(time.Minute).Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+//从墙上时间civil往前找第一个比t早的真实时间，不比bound晚的不要
+func (schedule *cronSchedule) backward(civil time.Time, loc *time.Location, t, bound time.Time) time.Time {
+	limit := civil.Year() - 5
+	for i := 0; i < cronAttempts; i++ {
+		civil = schedule.before(civil, limit)
+		if civil.IsZero() {
+			return time.Time{}
+		}
+		instants := schedule.instants(civil, loc)
+		for j := len(instants) - 1; j >= 0; j-- {
+			if !bound.IsZero() && !instants[j].After(bound) {
+				return time.Time{}
+			}
+			if instants[j].Before(t) {
+				return instants[j]
+			}
+		}
+		civil = civil.Truncate(time.Minute).Add(-time.Second)
+	}
+	return time.Time{}
+}
+
+//墙上时间t之后（包括t）第一个匹配的墙上时间
+func (schedule *cronSchedule) after(t time.Time, limit int) time.Time {
+	loc := time.UTC
+
+WRAP:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for schedule.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !schedule.dayMatch(t) {
+		month := t.Month()
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Month() != month {
+			goto WRAP
+		}
+	}
+	for schedule.hour&(1<<uint(t.Hour())) == 0 {
+		day := t.Day()
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Day() != day {
+			goto WRAP
+		}
+	}
+	for schedule.minute&(1<<uint(t.Minute())) == 0 {
+		hour := t.Hour()
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		if t.Hour() != hour {
+			goto WRAP
+		}
+	}
+	for schedule.second&(1<<uint(t.Second())) == 0 {
+		minute := t.Minute()
+		t = t.Add(time.Second)
+		if t.Minute() != minute {
+			goto WRAP
+		}
+	}
+
+	return t
+}
+
+//墙上时间t之前（包括t）最后一个匹配的墙上时间
+//往前找的时候，跳到上一个单位的最后一秒
+func (schedule *cronSchedule) before(t time.Time, limit int) time.Time {
+	loc := time.UTC
+
+WRAP:
+	if t.Year() < limit {
+		return time.Time{}
+	}
+	for schedule.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).Add(-time.Second)
+		if t.Month() == time.December {
+			goto WRAP
+		}
+	}
+	for !schedule.dayMatch(t) {
+		month := t.Month()
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Add(-time.Second)
+		if t.Month() != month {
+			goto WRAP
+		}
+	}
+	for schedule.hour&(1<<uint(t.Hour())) == 0 {
+		day := t.Day()
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(-time.Second)
+		if t.Day() != day {
+			goto WRAP
+		}
+	}
+	for schedule.minute&(1<<uint(t.Minute())) == 0 {
+		hour := t.Hour()
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(-time.Second)
+		if t.Hour() != hour {
+			goto WRAP
+		}
+	}
+	for schedule.second&(1<<uint(t.Second())) == 0 {
+		minute := t.Minute()
+		t = t.Add(-time.Second)
+		if t.Minute() != minute {
+			goto WRAP
+		}
+	}
+
+	return t
+}
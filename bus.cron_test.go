@@ -0,0 +1,181 @@
+package ark
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func cronZone(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestCronNext(t *testing.T) {
+	ny := cronZone(t, "America/New_York")
+	utc := time.UTC
+
+	//2026-03-08 02:00 跳到 03:00，2026-11-01 02:00 退回 01:00
+	//重复的一小时用UTC写再转到纽约，避免歧义
+	cases := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"spring gap skipped", "0 30 2 * * *",
+			time.Date(2026, 3, 7, 12, 0, 0, 0, ny), time.Date(2026, 3, 9, 2, 30, 0, 0, ny)},
+		{"spring hourly", "0 0 * * * *",
+			time.Date(2026, 3, 8, 1, 30, 0, 0, ny), time.Date(2026, 3, 8, 3, 0, 0, 0, ny)},
+		{"spring every second", "* * * * * *",
+			time.Date(2026, 3, 8, 1, 59, 59, 0, ny), time.Date(2026, 3, 8, 3, 0, 0, 0, ny)},
+		{"spring minutes in gap", "0 */15 2 * * *",
+			time.Date(2026, 3, 8, 0, 0, 0, 0, ny), time.Date(2026, 3, 9, 2, 0, 0, 0, ny)},
+		{"fall first occurrence", "0 30 1 * * *",
+			time.Date(2026, 11, 1, 0, 0, 0, 0, ny), time.Date(2026, 11, 1, 5, 30, 0, 0, utc)},
+		{"fall no second run", "0 30 1 * * *",
+			time.Date(2026, 11, 1, 5, 30, 0, 0, utc).In(ny), time.Date(2026, 11, 2, 1, 30, 0, 0, ny)},
+		{"fall no second run inside repeat", "0 30 1 * * *",
+			time.Date(2026, 11, 1, 6, 10, 0, 0, utc).In(ny), time.Date(2026, 11, 2, 1, 30, 0, 0, ny)},
+		{"fall hourly repeats", "0 0 * * * *",
+			time.Date(2026, 11, 1, 5, 30, 0, 0, utc).In(ny), time.Date(2026, 11, 1, 6, 0, 0, 0, utc)},
+		{"fall hourly after repeat", "0 0 * * * *",
+			time.Date(2026, 11, 1, 6, 0, 0, 0, utc).In(ny), time.Date(2026, 11, 1, 7, 0, 0, 0, utc)},
+		{"fall every second", "* * * * * *",
+			time.Date(2026, 11, 1, 5, 59, 59, 0, utc).In(ny), time.Date(2026, 11, 1, 6, 0, 0, 0, utc)},
+		{"daily across spring", "0 0 12 * * *",
+			time.Date(2026, 3, 7, 13, 0, 0, 0, ny), time.Date(2026, 3, 8, 12, 0, 0, 0, ny)},
+		{"feb 29", "0 0 0 29 2 *",
+			time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		{"feb 29 leap year", "0 0 0 29 2 *",
+			time.Date(2028, 1, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		{"feb 30 never", "0 0 0 30 2 *",
+			time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+		{"dom only", "0 0 0 13 * *",
+			time.Date(2026, 10, 18, 0, 0, 0, 0, utc), time.Date(2026, 11, 13, 0, 0, 0, 0, utc)},
+		{"dow only", "0 0 0 * * fri",
+			time.Date(2026, 10, 18, 0, 0, 0, 0, utc), time.Date(2026, 10, 23, 0, 0, 0, 0, utc)},
+		{"dom or dow", "0 0 0 13 * 5",
+			time.Date(2026, 10, 24, 0, 0, 0, 0, utc), time.Date(2026, 10, 30, 0, 0, 0, 0, utc)},
+		{"dom or dow dom first", "0 0 0 1-7 * mon",
+			time.Date(2026, 10, 28, 0, 0, 0, 0, utc), time.Date(2026, 11, 1, 0, 0, 0, 0, utc)},
+		{"dom with dow star", "0 0 0 31 * ?",
+			time.Date(2026, 4, 1, 0, 0, 0, 0, utc), time.Date(2026, 5, 31, 0, 0, 0, 0, utc)},
+		{"sunday as 7", "0 0 0 * * 7",
+			time.Date(2026, 10, 18, 0, 0, 0, 0, utc), time.Date(2026, 10, 25, 0, 0, 0, 0, utc)},
+		{"sub-second start", "* * * * * *",
+			time.Date(2026, 1, 1, 0, 0, 0, 500, utc), time.Date(2026, 1, 1, 0, 0, 1, 0, utc)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := cronParse(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := schedule.Next(tc.from)
+			if !got.Equal(tc.want) {
+				t.Fatalf("Next(%s) = %s, want %s", tc.from, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCronPrev(t *testing.T) {
+	ny := cronZone(t, "America/New_York")
+	utc := time.UTC
+
+	cases := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"spring gap skipped", "0 30 2 * * *",
+			time.Date(2026, 3, 9, 0, 0, 0, 0, ny), time.Date(2026, 3, 7, 2, 30, 0, 0, ny)},
+		{"spring hourly", "0 0 * * * *",
+			time.Date(2026, 3, 8, 3, 0, 0, 0, ny), time.Date(2026, 3, 8, 1, 0, 0, 0, ny)},
+		{"fall hourly repeats", "0 0 * * * *",
+			time.Date(2026, 11, 1, 6, 30, 0, 0, utc).In(ny), time.Date(2026, 11, 1, 6, 0, 0, 0, utc)},
+		{"fall first occurrence", "0 30 1 * * *",
+			time.Date(2026, 11, 1, 7, 0, 0, 0, utc).In(ny), time.Date(2026, 11, 1, 5, 30, 0, 0, utc)},
+		{"feb 29", "0 0 0 29 2 *",
+			time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Date(2024, 2, 29, 0, 0, 0, 0, utc)},
+		{"excludes t", "0 0 0 * * *",
+			time.Date(2026, 1, 2, 0, 0, 0, 0, utc), time.Date(2026, 1, 1, 0, 0, 0, 0, utc)},
+		{"dom or dow", "0 0 0 13 * 5",
+			time.Date(2026, 11, 14, 0, 0, 0, 0, utc), time.Date(2026, 11, 13, 0, 0, 0, 0, utc)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := cronParse(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := schedule.Prev(tc.from)
+			if !got.Equal(tc.want) {
+				t.Fatalf("Prev(%s) = %s, want %s", tc.from, got, tc.want)
+			}
+		})
+	}
+}
+
+//在夏令时切换的前后逐分钟走，每次都要比t晚或比t早，而且不会卡住
+func TestCronAroundTransitions(t *testing.T) {
+	exprs := []string{"* * * * * *", "0 * * * * *", "0 0 * * * *", "0 30 1 * * *", "0 30 2 * * *", "0 */7 1-3 * * *", "@every 1h"}
+	zones := []string{"America/New_York", "Europe/London", "Australia/Lord_Howe", "UTC"}
+	days := [][3]int{{2026, 3, 8}, {2026, 3, 29}, {2026, 4, 5}, {2026, 10, 4}, {2026, 10, 25}, {2026, 11, 1}}
+
+	for _, zone := range zones {
+		loc := cronZone(t, zone)
+		for _, expr := range exprs {
+			schedule, err := cronParse(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, day := range days {
+				start := time.Date(day[0], time.Month(day[1]), day[2], 0, 0, 0, 0, loc)
+				for at := start; at.Before(start.Add(5 * time.Hour)); at = at.Add(time.Minute + 7*time.Second) {
+					if next := schedule.Next(at); !next.After(at) {
+						t.Fatalf("%s %s Next(%s) = %s", zone, expr, at, next)
+					}
+					if prev := schedule.Prev(at); !prev.Before(at) {
+						t.Fatalf("%s %s Prev(%s) = %s", zone, expr, at, prev)
+					}
+				}
+			}
+		}
+	}
+}
+
+//往回拨的那天连续往后找，每秒的计划每一秒都触发，固定时间的只触发一次
+func TestCronFallSequence(t *testing.T) {
+	ny := cronZone(t, "America/New_York")
+	start := time.Date(2026, 11, 1, 4, 0, 0, 0, time.UTC).In(ny)
+	end := time.Date(2026, 11, 1, 8, 0, 0, 0, time.UTC)
+
+	every, _ := cronParse("* * * * * *")
+	for at := start; at.Before(end); {
+		next := every.Next(at)
+		if next.Sub(at) != time.Second {
+			t.Fatalf("every second: Next(%s) = %s", at, next)
+		}
+		at = next
+	}
+
+	counts := map[string]int{"0 30 1 * * *": 1, "0 0 * * * *": 3, "0 */20 1 * * *": 3}
+	for expr, want := range counts {
+		schedule, _ := cronParse(expr)
+		got := 0
+		for at := schedule.Next(start); at.Before(end); at = schedule.Next(at) {
+			got++
+		}
+		if got != want {
+			t.Fatalf("%s fired %d times, want %d", expr, got, want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package ark
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//队列重试和死信
+//失败的消息按 Queue.Backoff 指数退避重新入队，次数记在信封的 attempt 中
+//超过 Queue.Attempts 次后发到 <name>.dead 队列，由本模块保存到缓存中
+//死信默认不过期，一直保留到重放或清除，Queue.DeadExpiry 可以设置保留的时长
+//返回 Invalid 的消息重试也不会成功，直接进死信队列
+//<name>.dead 和原队列在同一条总线上，见 queueLocate
+//可以用 DeadLetters、DeadLetter、ReplayDeadLetters、PurgeDeadLetters 查看、重放和清除
+//死信只保存在缓存中，默认的内存缓存重启就没了，也看不到其它节点的死信
+//要可靠地查看和重放，缓存必须是redis这类外部驱动
+//保存失败时死信队列的消息不确认，由总线驱动重新投递，不会丢掉
+
+const (
+	busDeadSuffix = ".dead"
+	busDeadPrefix = "$bus.dead."
+)
+
+type (
+	// DeadLetter 死信
+	DeadLetter struct {
+		Id      string    `json:"id"`
+		Queue   string    `json:"queue"`
+		Time    time.Time `json:"time"`
+		Attempt int       `json:"attempt"`
+		Error   string    `json:"error"`
+		Trace   string    `json:"trace"`
+		Value   Map       `json:"value"`
+	}
+)
+
+//处理失败，还有次数的重新入队，否则进死信队列
+func (module *busModule) retrying(name string, envelope busEnvelope, res *Res) {
+	logger := ark.Logger.Logger("bus").With(Map{"queue": name, "trace": envelope.Trace})
+
+	config, ok := module.queues[name]
+	if !ok || config.Attempts <= 0 {
+		logger.Warning("[总线]队列处理失败", "result", res.Text)
+		return
+	}
+
+	envelope.Attempt++
+	envelope.Error = res.Text
+
+	if envelope.Attempt < config.Attempts && busRetryable(res) {
+		delay := config.Backoff
+		if delay <= 0 {
+			delay = time.Second
+		}
+		for i := 1; i < envelope.Attempt; i++ {
+			delay *= 2
+			if config.MaxBackoff > 0 && delay >= config.MaxBackoff {
+				delay = config.MaxBackoff
+				break
+			}
+		}
+
+		data, err := ark.Codec.Marshal(envelope)
+		if err == nil {
+			err = module.enqueuing(name, envelope.Key, data, delay)
+		}
+		if err != nil {
+			logger.Error("[总线]队列重试失败", "attempt", envelope.Attempt, "error", err)
+		} else {
+			logger.Info("[总线]队列重试", "attempt", envelope.Attempt, "delay", delay.String(), "result", res.Text)
+		}
+		return
+	}
+
+	data, err := ark.Codec.Marshal(envelope)
+	if err == nil {
+		err = module.enqueuing(name+busDeadSuffix, "", data)
+	}
+	if err != nil {
+		logger.Error("[总线]进入死信队列失败", "attempt", envelope.Attempt, "error", err)
+	} else {
+		logger.Warning("[总线]进入死信队列", "attempt", envelope.Attempt, "result", res.Text)
+	}
+}
+
+//数据无效的，重试多少次都一样
+func busRetryable(res *Res) bool {
+	return res.Text != Invalid.Text
+}
+
+//保存死信
+func (module *busModule) burying(queue string, envelope busEnvelope) error {
+	//用消息id，原始消息没有id的生成一个
+	id := envelope.Id
+	if id == "" {
+		id = ark.Codec.Unique()
+	}
+	letter := DeadLetter{
+		Id: id, Queue: queue, Time: time.Now(),
+		Attempt: envelope.Attempt, Error: envelope.Error, Trace: envelope.Trace,
+		Value: envelope.Value,
+	}
+
+	//为0时不过期
+	expiry := module.queues[queue].DeadExpiry
+
+	data, err := ark.Codec.Marshal(letter)
+	if err == nil {
+		err = ark.Cache.Write(busDeadPrefix+queue+"."+letter.Id, string(data), expiry)
+	}
+	if err != nil {
+		ark.Logger.Logger("bus").Error("[总线]保存死信失败", "queue", queue, "trace", envelope.Trace, "error", err)
+	}
+	return err
+}
+
+//缓存中的值，不同的驱动读出来可能是字符串或是字节
+func (module *busModule) unburying(value Any) (*DeadLetter, error) {
+	var data []byte
+	switch vv := value.(type) {
+	case string:
+		data = []byte(vv)
+	case []byte:
+		data = vv
+	default:
+		return nil, errors.New("无效的死信")
+	}
+
+	letter := &DeadLetter{}
+	if err := ark.Codec.Unmarshal(data, letter); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+// DeadLetters 列出队列的死信，按时间排序
+func (module *busModule) DeadLetters(queue string) ([]DeadLetter, error) {
+	keys, err := ark.Cache.Keys(busDeadPrefix + queue + ".")
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	for _, key := range keys {
+		//a.dead 和 a.b.dead 前缀会重叠，只要本队列的
+		if strings.Contains(strings.TrimPrefix(key, busDeadPrefix+queue+"."), ".") {
+			continue
+		}
+		value, err := ark.Cache.Read(key)
+		if err != nil || value == nil {
+			continue
+		}
+		if letter, err := module.unburying(value); err == nil {
+			letters = append(letters, *letter)
+		}
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].Time.Before(letters[j].Time)
+	})
+	return letters, nil
+}
+
+// DeadLetter 查看一条死信
+func (module *busModule) DeadLetter(queue, id string) (*DeadLetter, error) {
+	value, err := ark.Cache.Read(busDeadPrefix + queue + "." + id)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, errors.New("死信不存在")
+	}
+	return module.unburying(value)
+}
+
+// ReplayDeadLetters 重放死信，重新计算次数，不指定id时重放全部
+func (module *busModule) ReplayDeadLetters(queue string, ids ...string) (int, error) {
+	letters := []DeadLetter{}
+	if len(ids) == 0 {
+		all, err := module.DeadLetters(queue)
+		if err != nil {
+			return 0, err
+		}
+		letters = all
+	} else {
+		for _, id := range ids {
+			letter, err := module.DeadLetter(queue, id)
+			if err != nil {
+				return 0, err
+			}
+			letters = append(letters, *letter)
+		}
+	}
+
+	count := 0
+	for _, letter := range letters {
+		data, err := ark.Codec.Marshal(busEnvelope{
+			Version: busVersion, Id: letter.Id, Time: time.Now(), Node: ark.Config.Node.Id,
+			Type: busContentType, Trace: letter.Trace, Value: letter.Value,
+		})
+		if err != nil {
+			return count, err
+		}
+		if err := module.enqueuing(queue, "", data); err != nil {
+			return count, err
+		}
+		ark.Cache.Delete(busDeadPrefix + queue + "." + letter.Id)
+		count++
+	}
+	return count, nil
+}
+
+// PurgeDeadLetters 清除死信，不指定id时清除全部
+func (module *busModule) PurgeDeadLetters(queue string, ids ...string) error {
+	if len(ids) == 0 {
+		letters, err := module.DeadLetters(queue)
+		if err != nil {
+			return err
+		}
+		for _, letter := range letters {
+			ids = append(ids, letter.Id)
+		}
+	}
+	for _, id := range ids {
+		if err := ark.Cache.Delete(busDeadPrefix + queue + "." + id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//语法糖
+
+func DeadLetters(queue string) ([]DeadLetter, error) {
+	return ark.Bus.DeadLetters(queue)
+}
+func InspectDeadLetter(queue, id string) (*DeadLetter, error) {
+	return ark.Bus.DeadLetter(queue, id)
+}
+func ReplayDeadLetters(queue string, ids ...string) (int, error) {
+	return ark.Bus.ReplayDeadLetters(queue, ids...)
+}
+func PurgeDeadLetters(queue string, ids ...string) error {
+	return ark.Bus.PurgeDeadLetters(queue, ids...)
+}
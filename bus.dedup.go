@@ -0,0 +1,117 @@
+package ark
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//消费端去重，至少投递一次的消息可能会重复收到
+//事件和队列设置了 Dedup 的，处理成功后在缓存中写入去重记录，有效期内再收到同样的消息就跳过
+//处理期间用互斥锁抢占，同一条消息同时投递给两个消费者也只有一个在处理
+//抢占的有效期很短，处理期间自动续期，处理完不管成功失败都释放，进程挂掉的过一会儿也会过期
+//所以处理失败或中途挂掉的消息，重新投递时还会处理，不会被当成重复的丢掉
+//队列消息正在被别人处理的，延迟一会儿重新入队，等那边处理完再判断是否重复
+//默认按消息id判断，设置了 DedupBy 的，按这几个参数的值判断，比如订单号
+//没有信封的原始消息没有id，按整个值判断
+//事件每个节点都会收到，key带上节点id，队列集群中只处理一次，key是共用的
+//跨节点去重需要集群共享的缓存和互斥驱动
+
+const (
+	busDedupPrefix = "$bus.dedup."
+	busDedupClaim  = time.Second * 30
+	busDedupDelay  = time.Second * 5
+)
+
+//去重的key，不需要去重的返回空
+func (module *busModule) dedupKey(kind, name string, ttl time.Duration, keys []string, envelope busEnvelope) string {
+	if ttl <= 0 {
+		return ""
+	}
+
+	id := envelope.Id
+	if len(keys) > 0 || id == "" {
+		value := envelope.Value
+		if len(keys) > 0 {
+			value = Map{}
+			for _, key := range keys {
+				value[key] = envelope.Value[key]
+			}
+		}
+
+		//按key排序，同样的值生成同样的key
+		names := make([]string, 0, len(value))
+		for key := range value {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+
+		hash := sha1.New()
+		for _, key := range names {
+			fmt.Fprintf(hash, "%s=%v;", key, value[key])
+		}
+		id = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	if kind == BusEvent {
+		return fmt.Sprintf("%s%d.%s.%s", busDedupPrefix, ark.Config.Node.Id, name, id)
+	}
+	return busDedupPrefix + name + "." + id
+}
+
+//已经处理成功过的
+//缓存不可用的时候当做没有处理过
+func (module *busModule) deduped(key string) bool {
+	if key == "" {
+		return false
+	}
+	ok, err := ark.Cache.Exists(key)
+	if err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]读取去重记录失败", "key", key, "error", err)
+		return false
+	}
+	return ok
+}
+
+//抢占处理权，返回释放的函数，处理期间自动续期，处理完调用释放，可以重复调用
+//返回 ErrMutexLocked 说明别的消费者正在处理，互斥不可用的时候当做没人在处理
+func (module *busModule) claiming(key string) (func(), error) {
+	if key == "" {
+		return func() {}, nil
+	}
+	lock, err := ark.Mutex.Lock(key, busDedupClaim)
+	if err != nil {
+		if errors.Is(err, ErrMutexLocked) {
+			return nil, err
+		}
+		ark.Logger.Logger("bus").Warning("[总线]去重抢占失败", "key", key, "error", err)
+		return func() {}, nil
+	}
+
+	stop := lock.Watch()
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			stop()
+			if err := lock.Unlock(); err != nil {
+				ark.Logger.Logger("bus").Warning("[总线]释放去重失败", "key", key, "error", err)
+			}
+		})
+	}, nil
+}
+
+//处理成功，写入去重记录
+func (module *busModule) marking(key string, ttl time.Duration) {
+	if key == "" {
+		return
+	}
+	if err := ark.Cache.Write(key, "1", ttl); err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]写入去重记录失败", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,836 @@
+package ark
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//文件总线驱动，单节点部署不用消息中间件时使用，消息写到本地的追加日志，重启后继续处理
+//发布和入队先写日志再投递，处理成功后写一条确认，处理出错的按指数退避重新投递，次数用完丢弃
+//带分区key的队列消息，前一条确认之前，同一个key后面的消息都等着，重试中的也一样
+//延时消息也写在日志中，重启后没到时间的继续等，过了时间的马上投递
+//日志按大小分段，前面的段都处理完就删掉，剩下少量没处理的搬到当前段后删除旧段
+//同一个目录只能一个进程使用
+//[bus.default]
+//driver = "file"
+//[bus.default.setting]
+//path = "/var/lib/ark/bus"
+//segment = "64MB"    #分段大小
+//sync = false        #每次写入都落盘，更安全但是慢
+//attempts = 10       #处理出错最多投递的次数，0为不限，次数只记在内存中，重启后重新计算
+
+const (
+	fileBusExt         = ".log"
+	fileBusLock        = "LOCK"
+	fileBusSegmentSize = 64 << 20
+	fileBusRetry       = time.Second
+	fileBusRetryMax    = time.Minute
+	fileBusAttempts    = 10
+	fileBusCompact     = time.Minute
+
+	fileBusPut = "put"
+	fileBusAck = "ack"
+
+	fileBusKindEvent = "event"
+	fileBusKindQueue = "queue"
+)
+
+type (
+	fileBusDriver struct{}
+
+	fileBusConnect struct {
+		mutex   sync.Mutex
+		name    string
+		config  BusConfig
+		path    string
+		segment int64
+		syncing bool
+
+		//处理出错最多投递的次数
+		attempts int
+
+		eventHandler EventHandler
+		queueHandler QueueHandler
+
+		events map[string]bool
+		queues map[string]*fileBusQueue
+
+		locker   *os.File
+		file     *os.File
+		sequence uint64
+		segments []*fileBusSegment
+		pending  map[uint64]*fileBusMessage
+		delayed  fileBusDelayed
+
+		//分区key还没确认的消息，第一条在处理，后面的等着
+		keyed map[string][]*fileBusMessage
+
+		running bool
+		waking  chan bool
+		closing chan bool
+		workers sync.WaitGroup
+	}
+
+	//日志段，pending是段中还没有确认的消息数
+	fileBusSegment struct {
+		id      uint64
+		path    string
+		size    int64
+		pending int
+	}
+
+	//日志中的一条记录
+	fileBusRecord struct {
+		Op   string `json:"op"`
+		Id   uint64 `json:"id"`
+		Kind string `json:"kind,omitempty"`
+		Name string `json:"name,omitempty"`
+		Key  string `json:"key,omitempty"`
+		Due  int64  `json:"due,omitempty"`
+		Data []byte `json:"data,omitempty"`
+	}
+
+	//还没确认的消息，attempt是已经失败的次数
+	fileBusMessage struct {
+		record  fileBusRecord
+		size    int64
+		segment *fileBusSegment
+		held    bool
+		attempt int
+	}
+
+	//延时的消息，按时间排
+	fileBusDelayed []*fileBusMessage
+
+	fileBusQueue struct {
+		next    uint64 //轮流分配，放在最前面保证64位对齐
+		name    string
+		workers []*fileBusWorker
+	}
+
+	fileBusWorker struct {
+		mutex  sync.Mutex
+		cond   *sync.Cond
+		items  []*fileBusMessage
+		closed bool
+	}
+)
+
+func (driver *fileBusDriver) Connect(name string, config BusConfig) (BusConnect, error) {
+	connect := &fileBusConnect{
+		name: name, config: config,
+		path:     filepath.Join(os.TempDir(), "ark", "bus", name),
+		segment:  fileBusSegmentSize,
+		attempts: fileBusAttempts,
+		events:   make(map[string]bool),
+		queues:   make(map[string]*fileBusQueue),
+		pending:  make(map[uint64]*fileBusMessage),
+		keyed:    make(map[string][]*fileBusMessage),
+	}
+
+	if vv, ok := config.Setting["path"].(string); ok && vv != "" {
+		connect.path = vv
+	}
+	if vv, ok := config.Setting["segment"]; ok {
+		size, err := fileLoggerSize(vv)
+		if err != nil {
+			return nil, err
+		}
+		if size > 0 {
+			connect.segment = size
+		}
+	}
+	if vv, ok := config.Setting["sync"].(bool); ok {
+		connect.syncing = vv
+	}
+	if vv, ok := config.Setting["attempts"]; ok {
+		switch attempts := vv.(type) {
+		case int:
+			connect.attempts = attempts
+		case int64:
+			connect.attempts = int(attempts)
+		case float64:
+			connect.attempts = int(attempts)
+		default:
+			return nil, fmt.Errorf("无效的重试次数：%v", vv)
+		}
+	}
+
+	return connect, nil
+}
+
+//打开连接，加目录锁，重放日志恢复没有确认的消息
+func (connect *fileBusConnect) Open() error {
+	if err := os.MkdirAll(connect.path, 0755); err != nil {
+		return err
+	}
+
+	locker, err := os.OpenFile(filepath.Join(connect.path, fileBusLock), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	locked, err := fileMutexTryFlock(locker)
+	if err != nil && err != errFileMutexUnsupported {
+		locker.Close()
+		return err
+	}
+	if err == nil && !locked {
+		locker.Close()
+		return errors.New("总线目录已被其它进程使用：" + connect.path)
+	}
+	connect.locker = locker
+
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	if err := connect.loading(); err != nil {
+		connect.unlocking()
+		return err
+	}
+
+	//每次打开都用新的段，上次没写完的尾巴不会影响后面的写入
+	next := uint64(1)
+	if count := len(connect.segments); count > 0 {
+		next = connect.segments[count-1].id + 1
+	}
+	if err := connect.rolling(next); err != nil {
+		connect.unlocking()
+		return err
+	}
+	connect.compacting()
+
+	connect.waking = make(chan bool, 1)
+	connect.closing = make(chan bool)
+	return nil
+}
+
+func (connect *fileBusConnect) unlocking() {
+	if connect.file != nil {
+		connect.file.Close()
+		connect.file = nil
+	}
+	if connect.locker != nil {
+		fileMutexFunlock(connect.locker)
+		connect.locker.Close()
+		connect.locker = nil
+	}
+}
+
+func (connect *fileBusConnect) Health() (BusHealth, error) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	return BusHealth{Workload: int64(len(connect.pending))}, nil
+}
+
+//关闭，等处理中的消息结束，没处理的留在日志中，下次打开继续
+func (connect *fileBusConnect) Close() error {
+	connect.mutex.Lock()
+	if connect.closing == nil {
+		connect.mutex.Unlock()
+		return nil
+	}
+	close(connect.closing)
+	connect.closing = nil
+	connect.running = false
+	for _, queue := range connect.queues {
+		for _, worker := range queue.workers {
+			worker.close()
+		}
+	}
+	connect.mutex.Unlock()
+
+	connect.workers.Wait()
+
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	var err error
+	if connect.file != nil {
+		err = connect.file.Sync()
+	}
+	connect.unlocking()
+	return err
+}
+
+func (connect *fileBusConnect) Accept(eventHandler EventHandler, queueHandler QueueHandler) error {
+	connect.eventHandler = eventHandler
+	connect.queueHandler = queueHandler
+	return nil
+}
+
+func (connect *fileBusConnect) Event(name string) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	connect.events[name] = true
+	return nil
+}
+
+//重复订阅的，关掉原来的协程，还没处理的交给新的协程
+func (connect *fileBusConnect) Queue(name string, thread int) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	if thread <= 0 {
+		thread = 1
+	}
+	queue := &fileBusQueue{name: name}
+	for i := 0; i < thread; i++ {
+		worker := &fileBusWorker{}
+		worker.cond = sync.NewCond(&worker.mutex)
+		queue.workers = append(queue.workers, worker)
+	}
+
+	waiting := []*fileBusMessage{}
+	if old, ok := connect.queues[name]; ok {
+		for _, worker := range old.workers {
+			waiting = append(waiting, worker.drain()...)
+		}
+	}
+	connect.queues[name] = queue
+
+	//启动后才订阅的，开始处理之前积压的
+	if connect.running {
+		connect.starting(queue)
+		sort.Slice(waiting, func(i, j int) bool {
+			return waiting[i].record.Id < waiting[j].record.Id
+		})
+		for _, msg := range waiting {
+			connect.dispatching(msg)
+		}
+		for _, msg := range connect.sorted() {
+			if msg.held && msg.record.Name == name {
+				msg.held = false
+				connect.dispatching(msg)
+			}
+		}
+	}
+	return nil
+}
+
+//开始处理，恢复出来的消息按原来的顺序投递
+func (connect *fileBusConnect) Start() error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	if connect.running || connect.closing == nil {
+		return nil
+	}
+	connect.running = true
+
+	for _, queue := range connect.queues {
+		connect.starting(queue)
+	}
+	for _, msg := range connect.sorted() {
+		connect.dispatching(msg)
+	}
+
+	connect.workers.Add(1)
+	go connect.scheduling(connect.closing)
+	return nil
+}
+
+func (connect *fileBusConnect) starting(queue *fileBusQueue) {
+	for _, worker := range queue.workers {
+		connect.workers.Add(1)
+		go connect.working(queue.name, worker)
+	}
+}
+
+func (connect *fileBusConnect) working(name string, worker *fileBusWorker) {
+	defer connect.workers.Done()
+	for {
+		msg, ok := worker.pop()
+		if !ok {
+			return
+		}
+		connect.finishing(msg, connect.queueHandler(name, msg.record.Data))
+	}
+}
+
+//到时间的延时消息投递出去，顺便定时整理日志
+func (connect *fileBusConnect) scheduling(closing chan bool) {
+	defer connect.workers.Done()
+
+	ticker := time.NewTicker(fileBusCompact)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		connect.mutex.Lock()
+		wait := time.Hour
+		now := time.Now().UnixNano()
+		for len(connect.delayed) > 0 {
+			msg := connect.delayed[0]
+			if msg.record.Due > now {
+				wait = time.Duration(msg.record.Due - now)
+				break
+			}
+			heap.Pop(&connect.delayed)
+			connect.dispatching(msg)
+		}
+		connect.mutex.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-closing:
+			return
+		case <-connect.waking:
+		case <-timer.C:
+		case <-ticker.C:
+			connect.mutex.Lock()
+			connect.compacting()
+			connect.mutex.Unlock()
+		}
+	}
+}
+
+func (connect *fileBusConnect) wakeup() {
+	select {
+	case connect.waking <- true:
+	default:
+	}
+}
+
+//投递一条消息，调用时要持有锁
+//没到时间的进延时堆，事件开协程处理，队列按分区key进对应的协程，队列还没订阅的先留着
+func (connect *fileBusConnect) dispatching(msg *fileBusMessage) {
+	if !connect.running {
+		return
+	}
+	if msg.record.Due > time.Now().UnixNano() {
+		heap.Push(&connect.delayed, msg)
+		connect.wakeup()
+		return
+	}
+
+	switch msg.record.Kind {
+	case fileBusKindEvent:
+		//没有订阅的事件，确认掉
+		if _, ok := connect.events[msg.record.Name]; !ok {
+			connect.acking(msg)
+			return
+		}
+		connect.workers.Add(1)
+		go func() {
+			defer connect.workers.Done()
+			connect.finishing(msg, connect.eventHandler(msg.record.Name, msg.record.Data))
+		}()
+
+	case fileBusKindQueue:
+		queue, ok := connect.queues[msg.record.Name]
+		if !ok {
+			msg.held = true
+			return
+		}
+		if !connect.heading(msg) {
+			return
+		}
+		worker := queue.workers[0]
+		if count := len(queue.workers); count > 1 {
+			if msg.record.Key != "" {
+				hash := fnv.New32a()
+				hash.Write([]byte(msg.record.Key))
+				worker = queue.workers[int(hash.Sum32()%uint32(count))]
+			} else {
+				worker = queue.workers[int(atomic.AddUint64(&queue.next, 1)%uint64(count))]
+			}
+		}
+		worker.push(msg)
+
+	default:
+		connect.acking(msg)
+	}
+}
+
+//带分区key的队列消息排队，轮到自己了才投递，调用时要持有锁
+func (connect *fileBusConnect) heading(msg *fileBusMessage) bool {
+	if msg.record.Key == "" {
+		return true
+	}
+	id := msg.record.Name + "\x00" + msg.record.Key
+	waiting := connect.keyed[id]
+	if len(waiting) == 0 {
+		connect.keyed[id] = []*fileBusMessage{msg}
+		return true
+	}
+	if waiting[0] == msg {
+		return true
+	}
+	connect.keyed[id] = append(waiting, msg)
+	return false
+}
+
+//确认以后，同一个key的下一条可以投递了，调用时要持有锁
+func (connect *fileBusConnect) unheading(msg *fileBusMessage) {
+	if msg.record.Kind != fileBusKindQueue || msg.record.Key == "" {
+		return
+	}
+	id := msg.record.Name + "\x00" + msg.record.Key
+	waiting := connect.keyed[id]
+	if len(waiting) == 0 || waiting[0] != msg {
+		return
+	}
+	waiting[0] = nil
+	waiting = waiting[1:]
+	if len(waiting) == 0 {
+		delete(connect.keyed, id)
+		return
+	}
+	connect.keyed[id] = waiting
+	connect.dispatching(waiting[0])
+}
+
+//处理完成，成功的确认，失败的按指数退避重新投递，次数用完的丢掉
+func (connect *fileBusConnect) finishing(msg *fileBusMessage, err error) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	if err == nil {
+		connect.acking(msg)
+		return
+	}
+
+	msg.attempt++
+	if connect.attempts > 0 && msg.attempt >= connect.attempts {
+		ark.Logger.Logger("bus").Error("[总线]消息处理失败，已丢弃", "bus", connect.name, "name", msg.record.Name, "attempt", msg.attempt, "error", err)
+		connect.acking(msg)
+		return
+	}
+
+	delay := fileBusRetry
+	for i := 1; i < msg.attempt && delay < fileBusRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > fileBusRetryMax {
+		delay = fileBusRetryMax
+	}
+	msg.record.Due = time.Now().Add(delay).UnixNano()
+	connect.dispatching(msg)
+}
+
+func (connect *fileBusConnect) Publish(name string, data []byte, delays ...time.Duration) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	//没有订阅的事件直接丢掉
+	if _, ok := connect.events[name]; !ok {
+		return nil
+	}
+	return connect.putting(fileBusKindEvent, name, "", data, delays...)
+}
+
+func (connect *fileBusConnect) Enqueue(name string, data []byte, delays ...time.Duration) error {
+	return connect.EnqueueKey(name, "", data, delays...)
+}
+
+//分区入队，key相同的进同一个协程
+//还没订阅的队列也会写入日志，订阅以后再处理
+func (connect *fileBusConnect) EnqueueKey(name, key string, data []byte, delays ...time.Duration) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	return connect.putting(fileBusKindQueue, name, key, data, delays...)
+}
+
+//写入日志后投递
+func (connect *fileBusConnect) putting(kind, name, key string, data []byte, delays ...time.Duration) error {
+	if connect.file == nil {
+		return errors.New("总线未打开")
+	}
+
+	connect.sequence++
+	record := fileBusRecord{Op: fileBusPut, Id: connect.sequence, Kind: kind, Name: name, Key: key, Data: data}
+	if len(delays) > 0 && delays[0] > 0 {
+		record.Due = time.Now().Add(delays[0]).UnixNano()
+	}
+
+	size, err := connect.writing(record)
+	if err != nil {
+		return err
+	}
+
+	msg := &fileBusMessage{record: record, size: size, segment: connect.active()}
+	msg.segment.pending++
+	connect.pending[record.Id] = msg
+
+	connect.dispatching(msg)
+	connect.checking()
+	return nil
+}
+
+//确认，写入日志并从待处理中删除
+func (connect *fileBusConnect) acking(msg *fileBusMessage) {
+	if _, ok := connect.pending[msg.record.Id]; !ok {
+		return
+	}
+	delete(connect.pending, msg.record.Id)
+	msg.segment.pending--
+
+	if connect.file != nil {
+		if _, err := connect.writing(fileBusRecord{Op: fileBusAck, Id: msg.record.Id}); err != nil {
+			ark.Logger.Logger("bus").Warning("[总线]确认写入失败", "bus", connect.name, "name", msg.record.Name, "error", err)
+		}
+		connect.checking()
+	}
+	connect.unheading(msg)
+}
+
+//------------ 日志 ----------------
+
+func (connect *fileBusConnect) active() *fileBusSegment {
+	return connect.segments[len(connect.segments)-1]
+}
+
+//按id排序的待处理消息
+func (connect *fileBusConnect) sorted() []*fileBusMessage {
+	msgs := make([]*fileBusMessage, 0, len(connect.pending))
+	for _, msg := range connect.pending {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].record.Id < msgs[j].record.Id
+	})
+	return msgs
+}
+
+//写一条记录到当前段，格式为 长度(4) + crc32(4) + json
+func (connect *fileBusConnect) writing(record fileBusRecord) (int64, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+	frame := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[8:], payload)
+
+	if _, err := connect.file.Write(frame); err != nil {
+		return 0, err
+	}
+	if connect.syncing {
+		if err := connect.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	size := int64(len(frame))
+	connect.active().size += size
+	return size, nil
+}
+
+//当前段写满了，换新段并整理
+func (connect *fileBusConnect) checking() {
+	if connect.active().size < connect.segment {
+		return
+	}
+	if err := connect.rolling(connect.active().id + 1); err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]日志分段失败", "bus", connect.name, "error", err)
+		return
+	}
+	connect.compacting()
+}
+
+//新建一个段做为当前段
+func (connect *fileBusConnect) rolling(id uint64) error {
+	path := filepath.Join(connect.path, fmt.Sprintf("%016x%s", id, fileBusExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if connect.file != nil {
+		connect.file.Sync()
+		connect.file.Close()
+	}
+	connect.file = file
+	connect.segments = append(connect.segments, &fileBusSegment{id: id, path: path})
+	return nil
+}
+
+//整理日志，调用时要持有锁
+//旧段中没处理的消息占比少时搬到当前段，然后从最旧的开始删除处理完的段
+//确认记录只会在它对应的消息之后，所以只能从前往后删，不然重放时已确认的消息会复活
+func (connect *fileBusConnect) compacting() {
+	sealed := connect.segments[:len(connect.segments)-1]
+
+	total, live := int64(0), int64(0)
+	for _, segment := range sealed {
+		total += segment.size
+	}
+	for _, msg := range connect.pending {
+		if msg.segment != connect.active() {
+			live += msg.size
+		}
+	}
+
+	if live > 0 && live*2 < total {
+		for _, msg := range connect.sorted() {
+			if msg.segment == connect.active() {
+				continue
+			}
+			size, err := connect.writing(msg.record)
+			if err != nil {
+				ark.Logger.Logger("bus").Warning("[总线]日志整理失败", "bus", connect.name, "error", err)
+				break
+			}
+			msg.segment.pending--
+			msg.segment = connect.active()
+			msg.segment.pending++
+			msg.size = size
+		}
+	}
+
+	for len(connect.segments) > 1 && connect.segments[0].pending <= 0 {
+		if err := os.Remove(connect.segments[0].path); err != nil && !os.IsNotExist(err) {
+			ark.Logger.Logger("bus").Warning("[总线]日志删除失败", "bus", connect.name, "error", err)
+			return
+		}
+		connect.segments = connect.segments[1:]
+	}
+}
+
+//重放所有段，恢复待处理的消息，调用时要持有锁
+func (connect *fileBusConnect) loading() error {
+	files, err := filepath.Glob(filepath.Join(connect.path, "*"+fileBusExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), fileBusExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		segment := &fileBusSegment{id: id, path: path, size: int64(len(data))}
+		connect.segments = append(connect.segments, segment)
+
+		for offset := 0; offset+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+			checksum := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+			end := offset + 8 + length
+			if end > len(data) || crc32.ChecksumIEEE(data[offset+8:end]) != checksum {
+				//没写完的尾巴，后面的不要了
+				ark.Logger.Logger("bus").Warning("[总线]日志记录损坏", "bus", connect.name, "file", path, "offset", offset)
+				break
+			}
+
+			record := fileBusRecord{}
+			if err := json.Unmarshal(data[offset+8:end], &record); err == nil {
+				connect.replaying(segment, record, int64(end-offset))
+			}
+			if record.Id > connect.sequence {
+				connect.sequence = record.Id
+			}
+			offset = end
+		}
+	}
+	return nil
+}
+
+func (connect *fileBusConnect) replaying(segment *fileBusSegment, record fileBusRecord, size int64) {
+	switch record.Op {
+	case fileBusPut:
+		//整理时搬过的消息会出现两次，以后面的为准
+		if msg, ok := connect.pending[record.Id]; ok {
+			msg.segment.pending--
+		}
+		connect.pending[record.Id] = &fileBusMessage{record: record, size: size, segment: segment}
+		segment.pending++
+	case fileBusAck:
+		if msg, ok := connect.pending[record.Id]; ok {
+			delete(connect.pending, record.Id)
+			msg.segment.pending--
+		}
+	}
+}
+
+//------------ 队列协程 ----------------
+
+func (worker *fileBusWorker) push(msg *fileBusMessage) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	if worker.closed {
+		return
+	}
+	worker.items = append(worker.items, msg)
+	worker.cond.Signal()
+}
+
+func (worker *fileBusWorker) pop() (*fileBusMessage, bool) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	for len(worker.items) == 0 && !worker.closed {
+		worker.cond.Wait()
+	}
+	if worker.closed {
+		return nil, false
+	}
+	msg := worker.items[0]
+	worker.items[0] = nil
+	worker.items = worker.items[1:]
+	return msg, true
+}
+
+func (worker *fileBusWorker) close() {
+	worker.drain()
+}
+
+//关闭并取出还没处理的消息
+func (worker *fileBusWorker) drain() []*fileBusMessage {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	items := worker.items
+	worker.closed = true
+	worker.items = nil
+	worker.cond.Broadcast()
+	return items
+}
+
+//------------ 延时堆 ----------------
+
+func (delayed fileBusDelayed) Len() int { return len(delayed) }
+func (delayed fileBusDelayed) Less(i, j int) bool {
+	if delayed[i].record.Due == delayed[j].record.Due {
+		return delayed[i].record.Id < delayed[j].record.Id
+	}
+	return delayed[i].record.Due < delayed[j].record.Due
+}
+func (delayed fileBusDelayed) Swap(i, j int) { delayed[i], delayed[j] = delayed[j], delayed[i] }
+func (delayed *fileBusDelayed) Push(x interface{}) {
+	*delayed = append(*delayed, x.(*fileBusMessage))
+}
+func (delayed *fileBusDelayed) Pop() interface{} {
+	old := *delayed
+	n := len(old)
+	msg := old[n-1]
+	old[n-1] = nil
+	*delayed = old[:n-1]
+	return msg
+}
@@ -0,0 +1,272 @@
+package ark
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//文件总线的测试，每个测试一个临时目录，关掉再打开相当于重启
+
+type fileBusRecorder struct {
+	mutex  sync.Mutex
+	items  []string
+	failed map[string]int
+	fails  map[string]int
+}
+
+func (recorder *fileBusRecorder) handle(name string, data []byte) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	text := string(data)
+	if recorder.failed[text] < recorder.fails[text] {
+		recorder.failed[text]++
+		return errors.New("failed " + text)
+	}
+	recorder.items = append(recorder.items, text)
+	return nil
+}
+
+func (recorder *fileBusRecorder) list() []string {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]string{}, recorder.items...)
+}
+
+func (recorder *fileBusRecorder) tries(text string) int {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.failed[text]
+}
+
+func fileBusOpening(t *testing.T, setting Map, recorder *fileBusRecorder, queues ...string) *fileBusConnect {
+	conn, err := (&fileBusDriver{}).Connect("test", BusConfig{Setting: setting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := conn.(*fileBusConnect)
+	if err := connect.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if recorder != nil {
+		connect.Accept(recorder.handle, recorder.handle)
+	}
+	for _, queue := range queues {
+		if err := connect.Queue(queue, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return connect
+}
+
+func fileBusWaiting(t *testing.T, timeout time.Duration, check func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func fileBusSegments(t *testing.T, path string) []string {
+	files, err := filepath.Glob(filepath.Join(path, "*"+fileBusExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestFileBusReplay(t *testing.T) {
+	setting := Map{"path": t.TempDir()}
+
+	//没有开始处理就关掉，消息留在日志中
+	connect := fileBusOpening(t, setting, nil, "q")
+	for _, text := range []string{"a", "b", "c"} {
+		if err := connect.Enqueue("q", []byte(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	connect.Close()
+
+	recorder := &fileBusRecorder{}
+	connect = fileBusOpening(t, setting, recorder)
+	connect.Queue("q", 1)
+	connect.Start()
+	fileBusWaiting(t, time.Second, func() bool { return len(recorder.list()) == 3 })
+	connect.Close()
+	if got := recorder.list(); got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("replayed %v", got)
+	}
+
+	//确认过的不会再投递
+	again := &fileBusRecorder{}
+	connect = fileBusOpening(t, setting, again, "q")
+	connect.Start()
+	time.Sleep(100 * time.Millisecond)
+	connect.Close()
+	if got := again.list(); len(got) != 0 {
+		t.Fatalf("acked messages replayed: %v", got)
+	}
+}
+
+func TestFileBusTornTail(t *testing.T) {
+	path := t.TempDir()
+	setting := Map{"path": path}
+
+	connect := fileBusOpening(t, setting, nil, "q")
+	connect.Enqueue("q", []byte("a"))
+	connect.Enqueue("q", []byte("b"))
+	connect.Close()
+
+	//模拟写到一半断电，最后一段后面是半条记录
+	files := fileBusSegments(t, path)
+	file, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 1, 0, 1, 2, 3, 4, '{', '"'})
+	file.Close()
+
+	connect = fileBusOpening(t, setting, nil, "q")
+	connect.Enqueue("q", []byte("c"))
+	connect.Close()
+
+	recorder := &fileBusRecorder{}
+	connect = fileBusOpening(t, setting, recorder)
+	connect.Queue("q", 1)
+	connect.Start()
+	fileBusWaiting(t, time.Second, func() bool { return len(recorder.list()) == 3 })
+	connect.Close()
+	if got := recorder.list(); got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("replayed %v", got)
+	}
+}
+
+func TestFileBusCompaction(t *testing.T) {
+	path := t.TempDir()
+	setting := Map{"path": path, "segment": 1024}
+
+	//没人订阅的一条一直不确认，整理时要搬到新段
+	recorder := &fileBusRecorder{}
+	connect := fileBusOpening(t, setting, recorder, "q")
+	connect.Start()
+	connect.Enqueue("idle", []byte("idle"))
+	for i := 0; i < 200; i++ {
+		connect.Enqueue("q", []byte("x"))
+		fileBusWaiting(t, time.Second, func() bool { return len(recorder.list()) == i+1 })
+	}
+	connect.Close()
+
+	if files := fileBusSegments(t, path); len(files) > 4 {
+		t.Fatalf("%d segments left after compaction", len(files))
+	}
+
+	again := &fileBusRecorder{}
+	connect = fileBusOpening(t, setting, again, "q", "idle")
+	connect.Start()
+	fileBusWaiting(t, time.Second, func() bool { return len(again.list()) >= 1 })
+	time.Sleep(100 * time.Millisecond)
+	connect.Close()
+	if got := again.list(); len(got) != 1 || got[0] != "idle" {
+		t.Fatalf("after compaction got %v", got)
+	}
+}
+
+func TestFileBusDelayedRestart(t *testing.T) {
+	setting := Map{"path": t.TempDir()}
+
+	connect := fileBusOpening(t, setting, nil, "q")
+	connect.Enqueue("q", []byte("later"), 400*time.Millisecond)
+	connect.Enqueue("q", []byte("soon"), 50*time.Millisecond)
+	connect.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	//过了时间的马上投递，没到时间的继续等
+	recorder := &fileBusRecorder{}
+	connect = fileBusOpening(t, setting, recorder, "q")
+	connect.Start()
+	defer connect.Close()
+
+	fileBusWaiting(t, time.Second, func() bool { return len(recorder.list()) == 1 })
+	if got := recorder.list(); got[0] != "soon" {
+		t.Fatalf("got %v, want soon first", got)
+	}
+	fileBusWaiting(t, time.Second, func() bool { return len(recorder.list()) == 2 })
+}
+
+func TestFileBusRetryLimit(t *testing.T) {
+	setting := Map{"path": t.TempDir(), "attempts": 2}
+
+	recorder := &fileBusRecorder{failed: map[string]int{}, fails: map[string]int{"bad": 100}}
+	connect := fileBusOpening(t, setting, recorder, "q")
+	connect.Start()
+	defer connect.Close()
+
+	connect.Enqueue("q", []byte("bad"))
+	fileBusWaiting(t, 3*time.Second, func() bool { return recorder.tries("bad") == 2 })
+
+	//次数用完就确认掉，不再重试
+	fileBusWaiting(t, time.Second, func() bool {
+		health, _ := connect.Health()
+		return health.Workload == 0
+	})
+	time.Sleep(time.Second + 200*time.Millisecond)
+	if tries := recorder.tries("bad"); tries != 2 {
+		t.Fatalf("tried %d times, want 2", tries)
+	}
+}
+
+func TestFileBusKeyOrder(t *testing.T) {
+	setting := Map{"path": t.TempDir()}
+
+	//k1第一次失败，重试成功之前后面的k2、k3要等着，其它key不受影响
+	recorder := &fileBusRecorder{failed: map[string]int{}, fails: map[string]int{"k1": 1}}
+	connect := fileBusOpening(t, setting, recorder, "q")
+	connect.Start()
+	defer connect.Close()
+
+	connect.EnqueueKey("q", "key", []byte("k1"))
+	connect.EnqueueKey("q", "key", []byte("k2"))
+	connect.EnqueueKey("q", "key", []byte("k3"))
+	connect.EnqueueKey("q", "other", []byte("o1"))
+
+	fileBusWaiting(t, 3*time.Second, func() bool { return len(recorder.list()) == 4 })
+	got := recorder.list()
+	if got[0] != "o1" || got[1] != "k1" || got[2] != "k2" || got[3] != "k3" {
+		t.Fatalf("processed %v", got)
+	}
+}
+
+func TestFileBusQueueTwice(t *testing.T) {
+	setting := Map{"path": t.TempDir()}
+
+	recorder := &fileBusRecorder{}
+	connect := fileBusOpening(t, setting, recorder, "q")
+	connect.Start()
+
+	//运行中重复订阅，原来的协程要退出，不然关闭会一直等
+	if err := connect.Queue("q", 3); err != nil {
+		t.Fatal(err)
+	}
+	connect.Enqueue("q", []byte("a"))
+	fileBusWaiting(t, time.Second, func() bool { return len(recorder.list()) == 1 })
+
+	closed := make(chan bool)
+	go func() {
+		connect.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close hangs after subscribing twice")
+	}
+}
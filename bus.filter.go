@@ -0,0 +1,122 @@
+package ark
+
+import (
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//总线拦截器，和HTTP的拦截器一样，调用 ctx.Next() 继续，不调用直接返回结果就是拦截
+//Publish 在发出之前执行，包括事件、队列和请求，可以改Value，加Headers
+//Consume 包着处理方法执行，可以计时、检查Headers等
+//按注册的顺序执行，同名的覆盖时保留原来的位置
+//ark.Register("tenant", ark.BusFilter{
+//	Publish: func(ctx *ark.BusContext) *Res {
+//		ctx.Headers["tenant"] = "xxx"
+//		return ctx.Next()
+//	},
+//})
+
+const (
+	BusEvent   = "event"
+	BusQueue   = "queue"
+	BusRequest = "request"
+)
+
+type (
+	BusFunc func(*BusContext) *Res
+
+	BusFilter struct {
+		Name    string  `json:"name"`
+		Desc    string  `json:"desc"`
+		Publish BusFunc `json:"-"`
+		Consume BusFunc `json:"-"`
+	}
+
+	// BusContext 拦截器的上下文
+	BusContext struct {
+		*context
+		Kind    string
+		Name    string
+		Value   Map
+		Headers Map
+		Delays  []time.Duration
+
+		//队列的分区key
+		Key string
+
+		//消费的时候才有
+		Message Message
+
+		index   int
+		actions []BusFunc
+		final   func(*BusContext) *Res
+	}
+)
+
+// Filter 注册总线拦截器
+func (module *busModule) Filter(name string, config BusFilter, overrides ...bool) {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	override := true
+	if len(overrides) > 0 {
+		override = overrides[0]
+	}
+
+	if _, ok := module.filters[name]; ok {
+		if override {
+			module.filters[name] = config
+		}
+		return
+	}
+	module.filters[name] = config
+	module.filterNames = append(module.filterNames, name)
+}
+
+//按注册顺序取出拦截器
+func (module *busModule) filterActions(consume bool) []BusFunc {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	actions := make([]BusFunc, 0, len(module.filterNames))
+	for _, name := range module.filterNames {
+		config := module.filters[name]
+		if consume && config.Consume != nil {
+			actions = append(actions, config.Consume)
+		}
+		if !consume && config.Publish != nil {
+			actions = append(actions, config.Publish)
+		}
+	}
+	return actions
+}
+
+//执行拦截器，最后执行final
+func (module *busModule) filtering(bus *BusContext, consume bool, final func(*BusContext) *Res) *Res {
+	if bus.Value == nil {
+		bus.Value = Map{}
+	}
+	if bus.Headers == nil {
+		bus.Headers = Map{}
+	}
+	bus.index = 0
+	bus.actions = module.filterActions(consume)
+	bus.final = final
+	return bus.Next()
+}
+
+// Next 执行下一个拦截器，都执行完了执行实际的发送或处理
+func (bus *BusContext) Next() *Res {
+	if bus.index < len(bus.actions) {
+		action := bus.actions[bus.index]
+		bus.index++
+		return action(bus)
+	}
+	if bus.final != nil {
+		final := bus.final
+		bus.final = nil
+		return final(bus)
+	}
+	return nil
+}
@@ -3,16 +3,24 @@ package ark
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	. "github.com/arkgo/asset"
-	"github.com/arkgo/asset/cron"
 	"github.com/arkgo/asset/hashring"
 )
 
+const (
+	//信封版本，1只有跟踪id，2加上了消息id、时间、节点和内容类型
+	busVersion = 2
+
+	busContentType = "application/json"
+)
+
 type (
 	// BusConfig 总线配置
+	// Setting 中 validate = false 时，发到这条总线的消息不在发送端校验，用于外部的主题
 	BusConfig struct {
 		Driver  string `toml:"driver"`
 		Weight  int    `toml:"weight"`
@@ -50,6 +58,44 @@ type (
 		Enqueue(name string, data []byte, delays ...time.Duration) error
 	}
 
+	//总线消息信封，Publish和Enqueue发出的消息都会包一层
+	//ark是信封版本，用来和外部的原始消息区分
+	busEnvelope struct {
+		Version int       `json:"ark"`
+		Id      string    `json:"id,omitempty"`
+		Time    time.Time `json:"time,omitempty"`
+		Node    int64     `json:"node,omitempty"`
+		Type    string    `json:"type,omitempty"`
+		Trace   string    `json:"trace,omitempty"`
+		Attempt int       `json:"attempt,omitempty"`
+		Error   string    `json:"error,omitempty"`
+		Headers Map       `json:"headers,omitempty"`
+		Key     string    `json:"key,omitempty"`
+		Value   Map       `json:"value"`
+
+		//请求和回复，reply是回复地址，回复的correlation是请求的id
+		Reply       string `json:"reply,omitempty"`
+		Correlation string `json:"correlation,omitempty"`
+		Code        int    `json:"code,omitempty"`
+		State       string `json:"state,omitempty"`
+		Args        []Any  `json:"args,omitempty"`
+	}
+
+	// Message 总线消息的元数据，处理方法中用 p.Message() 获取
+	// 外部系统发来的原始消息没有信封，Version为0，只有Name和Time
+	Message struct {
+		Version int       `json:"version"`
+		Id      string    `json:"id"`
+		Name    string    `json:"name"`
+		Time    time.Time `json:"time"`
+		Node    int64     `json:"node"`
+		Type    string    `json:"type"`
+		Trace   string    `json:"trace"`
+		Attempt int       `json:"attempt"`
+		Key     string    `json:"key"`
+		Headers Map       `json:"headers"`
+	}
+
 	busModule struct {
 		mutex   sync.Mutex
 		drivers map[string]BusDriver
@@ -58,11 +104,24 @@ type (
 		events map[string]Event
 		queues map[string]Queue
 
+		//拦截器，按注册顺序
+		filters     map[string]BusFilter
+		filterNames []string
+
 		connects map[string]BusConnect
 		hashring *hashring.HashRing
 
-		cron        *cron.Cron
-		cronEntries map[string][]string
+		//计划调度，entries按计划名
+		entries map[string]*planEntry
+		paused  map[string]bool
+		runs    map[string]PlanRun
+		waking  chan bool
+		closing chan bool
+
+		//本节点的回复地址，和等待中的请求
+		reply      string
+		replyMutex sync.Mutex
+		replies    map[string]chan busEnvelope
 	}
 
 	Plan struct {
@@ -71,6 +130,15 @@ type (
 		Method string   `json:"method"`
 		Value  Map      `json:"value"`
 
+		//默认整个集群每次只执行一次，All为true时每个节点都执行
+		All bool `json:"all"`
+		//超时取消，方法中用 p.Context().Done() 判断
+		Timeout time.Duration `json:"timeout"`
+		//上一次还没执行完的时候，skip跳过，queue等上一次执行完，allow同时执行，默认skip
+		Overlap string `json:"overlap"`
+		//启动时补执行停机期间错过的一次，按最后一次执行的时间判断
+		Catchup bool `json:"catchup"`
+
 		Name     string   `json:"name"`
 		Desc     string   `json:"desc"`
 		Alias    []string `json:"alias"`
@@ -81,7 +149,12 @@ type (
 		Action   Any      `json:"-"`
 	}
 	Event struct {
-		Bus      string   `json:"bus"`
+		Bus string `json:"bus"`
+
+		//去重的有效期，为0不去重，DedupBy是用来判断重复的参数，为空按消息id
+		Dedup   time.Duration `json:"dedup"`
+		DedupBy []string      `json:"dedupBy"`
+
 		Name     string   `json:"name"`
 		Desc     string   `json:"desc"`
 		Alias    []string `json:"alias"`
@@ -93,8 +166,21 @@ type (
 	}
 	//Queue 目前基本只用于外部队列
 	Queue struct {
-		Bus      string   `json:"bus"`
-		Thread   int      `json:"thread"`
+		Bus    string `json:"bus"`
+		Thread int    `json:"thread"`
+
+		//重试策略，最多执行Attempts次，失败后按Backoff指数退避重新入队
+		//用完后进入死信队列 <name>.dead，Attempts为0不重试也不进死信
+		//DeadExpiry是死信保留的时长，为0不过期，一直保留到重放或清除
+		Attempts   int           `json:"attempts"`
+		Backoff    time.Duration `json:"backoff"`
+		MaxBackoff time.Duration `json:"maxBackoff"`
+		DeadExpiry time.Duration `json:"deadExpiry"`
+
+		//去重的有效期，为0不去重，DedupBy是用来判断重复的参数，为空按消息id
+		Dedup   time.Duration `json:"dedup"`
+		DedupBy []string      `json:"dedupBy"`
+
 		Name     string   `json:"name"`
 		Desc     string   `json:"desc"`
 		Alias    []string `json:"alias"`
@@ -114,7 +200,16 @@ func newBus() *busModule {
 		events: make(map[string]Event),
 		queues: make(map[string]Queue),
 
+		filters:     make(map[string]BusFilter),
+		filterNames: make([]string, 0),
+
 		connects: make(map[string]BusConnect, 0),
+
+		entries: make(map[string]*planEntry),
+		paused:  make(map[string]bool),
+		runs:    make(map[string]PlanRun),
+
+		replies: make(map[string]chan busEnvelope),
 	}
 }
 
@@ -155,6 +250,12 @@ func (module *busModule) Plan(name string, config Plan, overrides ...bool) {
 		config.Times = append(config.Times, config.Time)
 		config.Time = ""
 	}
+	if config.Overlap == "" {
+		config.Overlap = PlanSkip
+	}
+	if config.Overlap != PlanSkip && config.Overlap != PlanQueue && config.Overlap != PlanAllow {
+		panic("[总线]无效的计划并发策略：" + config.Overlap)
+	}
 
 	if config.Action != nil {
 		//如果action不为空，代注册方法
@@ -186,10 +287,14 @@ func (module *busModule) Plan(name string, config Plan, overrides ...bool) {
 				module.plans[key] = config
 			}
 		}
-	}
-}
-func (module *busModule) plan(name string, config Plan) {
 
+		//已经开始调度的，运行时注册的计划直接加入调度
+		if module.closing != nil {
+			if err := module.plan(key, module.plans[key]); err != nil {
+				panic("[总线]注册计划失败：" + err.Error())
+			}
+		}
+	}
 }
 
 func (module *busModule) Event(name string, config Event, overrides ...bool) {
@@ -274,35 +379,23 @@ func (module *busModule) connecting(name string, config BusConfig) (BusConnect,
 func (module *busModule) initing() {
 
 	//开始计划
-	module.cron = cron.New()
-	module.cronEntries = make(map[string][]string)
-
-	for key, val := range module.plans {
-		name := key
-		config := val
-
-		ids := make([]string, 0)
-		for i, crontab := range config.Times {
-			timeName := fmt.Sprintf("%s.%v", key, i)
-			id, err := module.cron.AddFunc(crontab, func() {
-				//超时，那边要判断是不是主节点
-				module.planning(name, config)
-			}, &cron.Extra{Name: timeName, RunForce: false, TimeOut: 5})
-
-			if err != nil {
-				panic("[总线]注册计划失败")
-			}
-
-			ids = append(ids, id)
+	module.mutex.Lock()
+	for name, config := range module.plans {
+		if err := module.plan(name, config); err != nil {
+			module.mutex.Unlock()
+			panic("[总线]注册计划失败：" + err.Error())
 		}
-
-		module.cronEntries[name] = ids
 	}
-
-	module.cron.Start()
+	module.waking = make(chan bool, 1)
+	module.closing = make(chan bool)
+	module.mutex.Unlock()
 
 	//-----------------------注册事件和队列--------------------
 
+	//回复地址，只跟节点id有关，重启后还是同一个队列，持久化的驱动不会留下没人要的队列
+	//节点id在集群中要唯一，否则回复可能被同id的其它进程收走
+	module.reply = fmt.Sprintf("%s%d", busReplyPrefix, ark.Config.Node.Id)
+
 	weights := make(map[string]int)
 	for busName, busConfig := range ark.Config.Bus {
 
@@ -319,6 +412,12 @@ func (module *busModule) initing() {
 		if err != nil {
 			panic("[总线]注册失败：" + err.Error())
 		}
+		//驱动原生支持请求回复的
+		if requester, ok := connect.(BusRequester); ok {
+			if err := requester.AcceptRequest(module.requesting); err != nil {
+				panic("[总线]注册失败：" + err.Error())
+			}
+		}
 
 		//权重大于0，才表示是本系统自动要使用的消息服务
 		//如果小于等于0，则表示是外接的消息系统，就不订阅
@@ -339,9 +438,20 @@ func (module *busModule) initing() {
 				if err := connect.Queue(queueName, queueConfig.Thread); err != nil {
 					panic("[总线]注册队列失败：" + err.Error())
 				}
+				//有重试策略的，同时订阅死信队列
+				if queueConfig.Attempts > 0 {
+					if err := connect.Queue(queueName+busDeadSuffix, 1); err != nil {
+						panic("[总线]注册死信队列失败：" + err.Error())
+					}
+				}
 			}
 		}
 
+		//回复队列，每条总线都订阅，回复从哪条总线来都可以收到
+		if err := connect.Queue(module.reply, 1); err != nil {
+			panic("[总线]注册回复队列失败：" + err.Error())
+		}
+
 		err = connect.Start()
 		if err != nil {
 			panic("[总线]启动失败：" + err.Error())
@@ -353,26 +463,192 @@ func (module *busModule) initing() {
 	//hashring分片
 	module.hashring = hashring.New(weights)
 }
+
+//所有模块初始化以后开始调度计划，计划要用到缓存和数据库
+func (module *busModule) launching() {
+	module.mutex.Lock()
+	for name, entry := range module.entries {
+		if entry.config.Catchup {
+			go module.catching(name, entry.config, entry.preving(time.Now()))
+		}
+	}
+	module.mutex.Unlock()
+
+	go module.scheduling()
+}
+
 func (module *busModule) exiting() {
-	if module.cron != nil {
-		module.cron.Stop()
+	module.mutex.Lock()
+	if module.closing != nil {
+		close(module.closing)
+		module.closing = nil
 	}
+	module.mutex.Unlock()
 	for _, connect := range module.connects {
 		connect.Close()
 	}
 }
 
-//收到计划
-func (module *busModule) planning(name string, config Plan) {
-	ark.Service.Invoke(nil, config.Method, config.Value)
+//信封编码
+func (module *busModule) encoding(bus *BusContext) ([]byte, error) {
+	//待优化，可能使用其它方式来编码
+	envelope := busEnvelope{
+		Version: busVersion, Id: ark.Codec.Unique(), Time: time.Now(),
+		Node: ark.Config.Node.Id, Type: busContentType, Trace: bus.TraceId(),
+		Key: bus.Key, Value: bus.Value,
+	}
+	if len(bus.Headers) > 0 {
+		envelope.Headers = bus.Headers
+	}
+	return ark.Codec.Marshal(envelope)
+}
+
+//发送用的拦截器上下文，没有上下文的新建一个，返回的函数用来结束
+func (module *busModule) sending(ctx *context, kind, name string, value Map, delays ...time.Duration) (*BusContext, func()) {
+	done := func() {}
+	if ctx == nil {
+		ctx = newcontext()
+		done = ctx.terminal
+	}
+	return &BusContext{context: ctx, Kind: kind, Name: name, Value: value, Delays: delays}, done
+}
+
+//信封解码，不是信封的，当做原始消息处理
+//版本1的信封没有id等信息，一样可以解开
+func (module *busModule) decoding(data []byte) (busEnvelope, error) {
+	value := Map{}
+	if err := ark.Codec.Unmarshal(data, &value); err != nil {
+		return busEnvelope{}, err
+	}
+
+	if busEnveloped(value) {
+		envelope := busEnvelope{}
+		if err := ark.Codec.Unmarshal(data, &envelope); err == nil {
+			if envelope.Value == nil {
+				envelope.Value = Map{}
+			}
+			return envelope, nil
+		}
+	}
+	return busEnvelope{Value: value}, nil
+}
+
+//信封中可能出现的字段
+var busEnvelopeKeys = map[string]bool{
+	"ark": true, "id": true, "time": true, "node": true, "type": true, "trace": true,
+	"attempt": true, "error": true, "headers": true, "key": true, "value": true,
+	"reply": true, "correlation": true, "code": true, "state": true, "args": true,
+}
+
+//是不是信封，外部消息碰巧有ark字段的不算
+//ark是已知的版本号，value是对象，并且没有信封以外的字段
+func busEnveloped(raw Map) bool {
+	version := 0
+	switch vv := raw["ark"].(type) {
+	case float64:
+		if vv != float64(int(vv)) {
+			return false
+		}
+		version = int(vv)
+	case int:
+		version = vv
+	case int64:
+		version = int(vv)
+	default:
+		return false
+	}
+	if version < 1 || version > busVersion {
+		return false
+	}
+
+	value, ok := raw["value"]
+	if !ok {
+		return false
+	}
+	if _, ok := value.(Map); !ok && value != nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+
+	for key := range raw {
+		if !busEnvelopeKeys[key] {
+			return false
+		}
+	}
+	return true
+}
+
+//处理消息的上下文，带上跟踪id和消息元数据
+func (module *busModule) contexting(name string, envelope busEnvelope) *context {
+	ctx := newcontext()
+	ctx.TraceId(envelope.Trace)
+
+	msg := Message{
+		Version: envelope.Version, Id: envelope.Id, Name: name, Time: envelope.Time,
+		Node: envelope.Node, Type: envelope.Type, Trace: ctx.TraceId(), Attempt: envelope.Attempt,
+		Key: envelope.Key, Headers: envelope.Headers,
+	}
+	if msg.Headers == nil {
+		msg.Headers = Map{}
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	ctx.message = &msg
+
+	return ctx
+}
+
+//经过拦截器执行处理方法
+func (module *busModule) consuming(ctx *context, kind, name string, value Map) (Map, *Res) {
+	bus := &BusContext{
+		context: ctx, Kind: kind, Name: name, Value: value,
+		Headers: ctx.message.Headers, Message: *ctx.message,
+	}
+
+	var data Map
+	res := module.filtering(bus, true, func(bus *BusContext) *Res {
+		var res *Res
+		data, res = ark.Service.Invoke(bus.context, bus.Name, bus.Value)
+		return res
+	})
+	return data, res
 }
 
 //收到事件和队列
+//使用消息中的跟踪id，消费者的日志和调用可以和生产者对应上
 func (module *busModule) eventing(name string, data []byte) error {
-	value := Map{}
-	err := ark.Codec.Unmarshal(data, &value)
-	if err == nil {
-		ark.Service.Invoke(nil, name, value)
+	envelope, err := module.decoding(data)
+	if err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]事件解析失败", "event", name, "error", err)
+		return nil
+	}
+
+	//事件的key带节点id，正在处理的只可能是本节点收到的同一条
+	config := module.events[name]
+	key := module.dedupKey(BusEvent, name, config.Dedup, config.DedupBy, envelope)
+	if module.deduped(key) {
+		ark.Logger.Logger("bus").Debug("[总线]重复的事件", "event", name, "id", envelope.Id, "trace", envelope.Trace)
+		return nil
+	}
+	release, err := module.claiming(key)
+	if err != nil {
+		ark.Logger.Logger("bus").Debug("[总线]事件正在处理", "event", name, "id", envelope.Id, "trace", envelope.Trace)
+		return nil
+	}
+	defer release()
+	//抢到之前可能刚被处理完
+	if module.deduped(key) {
+		return nil
+	}
+
+	ctx := module.contexting(name, envelope)
+	defer ctx.terminal()
+
+	_, res := module.consuming(ctx, BusEvent, name, envelope.Value)
+	if res == nil || !res.Fail() {
+		module.marking(key, config.Dedup)
 	}
 
 	return nil
@@ -388,54 +664,203 @@ func (module *busModule) queueing(name string, data []byte) error {
 	// 	}
 	// }
 
-	value := Map{}
-	err := ark.Codec.Unmarshal(data, &value)
-	if err == nil {
-		ark.Service.Invoke(nil, name, value)
+	envelope, err := module.decoding(data)
+	if err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]队列解析失败", "queue", name, "error", err)
+		return nil
+	}
+
+	//发给本节点的回复
+	if name == module.reply {
+		module.replied(envelope)
+		return nil
+	}
+
+	//请求，执行后回复，不重试
+	if envelope.Reply != "" {
+		module.responding(name, envelope)
+		return nil
+	}
+
+	//死信队列，保存下来，以便查看和重放
+	if queue := strings.TrimSuffix(name, busDeadSuffix); queue != name {
+		if config, ok := module.queues[queue]; ok && config.Attempts > 0 {
+			return module.burying(queue, envelope)
+		}
+	}
+
+	config := module.queues[name]
+	key := module.dedupKey(BusQueue, name, config.Dedup, config.DedupBy, envelope)
+	if module.deduped(key) {
+		ark.Logger.Logger("bus").Debug("[总线]重复的队列消息", "queue", name, "id", envelope.Id, "trace", envelope.Trace)
+		return nil
+	}
+	//别人正在处理，过一会儿再来，那边成功了到时就是重复的，失败了还可以处理
+	release, err := module.claiming(key)
+	if err != nil {
+		ark.Logger.Logger("bus").Debug("[总线]队列消息正在处理", "queue", name, "id", envelope.Id, "trace", envelope.Trace)
+		return module.enqueuing(name, envelope.Key, data, busDedupDelay)
+	}
+	defer release()
+	if module.deduped(key) {
+		return nil
+	}
+
+	ctx := module.contexting(name, envelope)
+	defer ctx.terminal()
+
+	_, res := module.consuming(ctx, BusQueue, name, envelope.Value)
+	if res == nil || !res.Fail() {
+		module.marking(key, config.Dedup)
+	}
+	//先释放再重试，重新入队的消息才能再抢到
+	release()
+	if res != nil && res.Fail() {
+		module.retrying(name, envelope, res)
 	}
 
 	return nil
 }
 
-// Publish 发起事件
-func (module *busModule) Publish(name string, value Map, delays ...time.Duration) error {
-	//待优化，可能使用其它方式来编码
+//发送端校验，按注册的Args解析，失败时直接返回结果，不用等消费端处理的时候才发现
+//总线设置了 validate = false 的跳过，本节点没有注册的也跳过
+//定义的 Setting 中 encode = true 时，发出去的是解析后的值
+func (module *busModule) validating(ctx *context, bus string, args Vars, nullable bool, setting Map, value Map) (Map, *Res) {
 	if value == nil {
 		value = Map{}
 	}
-	data, err := ark.Codec.Marshal(value)
-	if err != nil {
-		return err
+	if args == nil {
+		return value, nil
+	}
+	if config, ok := ark.Config.Bus[bus]; ok {
+		if vv, ok := config.Setting["validate"].(bool); ok && vv == false {
+			return value, nil
+		}
+	}
+
+	out := Map{}
+	if res := ark.Basic.Mapping(args, value, out, nullable, false, ctx); res != nil {
+		return nil, res
 	}
+	if vv, ok := setting["encode"].(bool); ok && vv {
+		return out, nil
+	}
+	return value, nil
+}
 
-	//使用权重来发决定，使用哪一条总线
-	locate := DEFAULT
+//事件使用权重来决定总线
+func (module *busModule) eventLocate(name string) string {
+	if config, ok := module.events[name]; ok && config.Bus != "" && config.Bus != "*" {
+		return config.Bus
+	}
 	if module.hashring != nil {
-		locate = module.hashring.Locate(name)
+		return module.hashring.Locate(name)
 	}
+	return DEFAULT
+}
+
+//队列指定了总线的，使用指定的总线，否则使用权重来发
+func (module *busModule) queueLocate(name string) string {
+	//死信队列只在原队列的总线上订阅
+	if queue := strings.TrimSuffix(name, busDeadSuffix); queue != name {
+		if _, ok := module.queues[queue]; ok {
+			name = queue
+		}
+	}
+	if config, ok := module.queues[name]; ok && config.Bus != "" && config.Bus != "*" {
+		return config.Bus
+	}
+	if module.hashring != nil {
+		return module.hashring.Locate(name)
+	}
+	return DEFAULT
+}
+
+// Publish 发起事件
+func (module *busModule) Publish(name string, value Map, delays ...time.Duration) *Res {
+	return module.publish(nil, name, value, delays...)
+}
+func (module *busModule) publish(ctx *context, name string, value Map, delays ...time.Duration) *Res {
+	bus, done := module.sending(ctx, BusEvent, name, value, delays...)
+	defer done()
+	return module.filtering(bus, false, module.publishing)
+}
+func (module *busModule) publishing(bus *BusContext) *Res {
+	locate := module.eventLocate(bus.Name)
+	if config, ok := module.events[bus.Name]; ok {
+		vvv, res := module.validating(bus.context, locate, config.Args, config.Nullable, config.Setting, bus.Value)
+		if res != nil {
+			return res
+		}
+		bus.Value = vvv
+	}
+
+	data, err := module.encoding(bus)
+	if err != nil {
+		return errResult(err)
+	}
+
+	//事务中的，先写到发件箱
+	if outbox := bus.outbox(); outbox != nil {
+		if err := outbox.outbox(busOutboxEvent, bus.Name, data, bus.Delays...); err != nil {
+			return errResult(err)
+		}
+		return nil
+	}
+
 	if connect, ok := module.connects[locate]; ok {
-		return connect.Publish(name, data, delays...)
+		if err := connect.Publish(bus.Name, data, bus.Delays...); err != nil {
+			return errResult(err)
+		}
+		return nil
 	}
-	return errors.New("发布失败")
+	return errResult(errors.New("发布失败"))
 }
 
 // Enqueue 发起队列
-func (module *busModule) Enqueue(name string, value Map, delays ...time.Duration) error {
-	//待优化，可能使用其它方式来编码
-	if value == nil {
-		value = Map{}
+func (module *busModule) Enqueue(name string, value Map, delays ...time.Duration) *Res {
+	return module.enqueue(nil, name, value, delays...)
+}
+func (module *busModule) enqueue(ctx *context, name string, value Map, delays ...time.Duration) *Res {
+	bus, done := module.sending(ctx, BusQueue, name, value, delays...)
+	defer done()
+	return module.filtering(bus, false, module.enqueueing)
+}
+func (module *busModule) enqueueing(bus *BusContext) *Res {
+	if config, ok := module.queues[bus.Name]; ok {
+		vvv, res := module.validating(bus.context, module.queueLocate(bus.Name), config.Args, config.Nullable, config.Setting, bus.Value)
+		if res != nil {
+			return res
+		}
+		bus.Value = vvv
 	}
-	data, err := ark.Codec.Marshal(value)
+
+	data, err := module.encoding(bus)
 	if err != nil {
-		return err
+		return errResult(err)
 	}
 
-	//使用权重来发消息
-	locate := DEFAULT
-	if module.hashring != nil {
-		locate = module.hashring.Locate(name)
+	//事务中的，先写到发件箱
+	if outbox := bus.outbox(); outbox != nil {
+		if err := outbox.outbox(busOutboxQueue, bus.Name, data, bus.Delays...); err != nil {
+			return errResult(err)
+		}
+		return nil
 	}
-	if connect, ok := module.connects[locate]; ok {
+
+	if err := module.enqueuing(bus.Name, bus.Key, data, bus.Delays...); err != nil {
+		return errResult(err)
+	}
+	return nil
+}
+
+//发送队列消息，已编码好的，重试和死信也走这里
+//有分区key并且驱动支持分区的，按分区发送
+func (module *busModule) enqueuing(name, key string, data []byte, delays ...time.Duration) error {
+	if connect, ok := module.connects[module.queueLocate(name)]; ok {
+		if partitioner, ok := connect.(BusPartitioner); ok && key != "" {
+			return partitioner.EnqueueKey(name, key, data, delays...)
+		}
 		return connect.Enqueue(name, data, delays...)
 	}
 
@@ -469,7 +894,7 @@ func (module *busModule) EnqueueTo(bus string, name string, data []byte, delays
 // }
 
 // Publish 是发起事件
-func Publish(name string, value Map, delays ...time.Duration) error {
+func Publish(name string, value Map, delays ...time.Duration) *Res {
 	return ark.Bus.Publish(name, value, delays...)
 }
 
@@ -492,7 +917,7 @@ func PublishDataTo(bus string, name string, data []byte, delays ...time.Duration
 }
 
 // Enqueue 是发起队列
-func Enqueue(name string, value Map, delays ...time.Duration) error {
+func Enqueue(name string, value Map, delays ...time.Duration) *Res {
 	return ark.Bus.Enqueue(name, value, delays...)
 }
 
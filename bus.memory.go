@@ -0,0 +1,257 @@
+package ark
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//内存总线驱动，单进程内有效，默认驱动，重启后未处理的消息会丢失
+//事件发给所有订阅，队列按 thread 开多个协程处理
+//支持分区，同一个分区key的消息总是由同一个协程按顺序处理
+
+type (
+	memoryBusDriver struct{}
+
+	memoryBusConnect struct {
+		mutex  sync.RWMutex
+		name   string
+		config BusConfig
+
+		eventHandler EventHandler
+		queueHandler QueueHandler
+
+		events map[string]bool
+		queues map[string]*memoryBusQueue
+
+		running bool
+		closing chan bool
+		workers sync.WaitGroup
+	}
+
+	//一个队列，每个协程一个先进先出的列表
+	memoryBusQueue struct {
+		next    uint64 //轮流分配，放在最前面保证64位对齐
+		name    string
+		workers []*memoryBusWorker
+	}
+
+	memoryBusWorker struct {
+		mutex  sync.Mutex
+		cond   *sync.Cond
+		items  [][]byte
+		closed bool
+	}
+)
+
+func (driver *memoryBusDriver) Connect(name string, config BusConfig) (BusConnect, error) {
+	return &memoryBusConnect{
+		name: name, config: config,
+		events: make(map[string]bool),
+		queues: make(map[string]*memoryBusQueue),
+	}, nil
+}
+
+func (connect *memoryBusConnect) Open() error {
+	connect.closing = make(chan bool)
+	return nil
+}
+
+func (connect *memoryBusConnect) Health() (BusHealth, error) {
+	connect.mutex.RLock()
+	defer connect.mutex.RUnlock()
+
+	workload := int64(0)
+	for _, queue := range connect.queues {
+		for _, worker := range queue.workers {
+			worker.mutex.Lock()
+			workload += int64(len(worker.items))
+			worker.mutex.Unlock()
+		}
+	}
+	return BusHealth{Workload: workload}, nil
+}
+
+//关闭，等处理中的消息结束，延时的和没处理的丢掉
+func (connect *memoryBusConnect) Close() error {
+	connect.mutex.Lock()
+	if connect.closing == nil {
+		connect.mutex.Unlock()
+		return nil
+	}
+	close(connect.closing)
+	connect.closing = nil
+	for _, queue := range connect.queues {
+		for _, worker := range queue.workers {
+			worker.close()
+		}
+	}
+	connect.mutex.Unlock()
+
+	connect.workers.Wait()
+	return nil
+}
+
+func (connect *memoryBusConnect) Accept(eventHandler EventHandler, queueHandler QueueHandler) error {
+	connect.eventHandler = eventHandler
+	connect.queueHandler = queueHandler
+	return nil
+}
+
+func (connect *memoryBusConnect) Event(name string) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	connect.events[name] = true
+	return nil
+}
+
+func (connect *memoryBusConnect) Queue(name string, thread int) error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	if thread <= 0 {
+		thread = 1
+	}
+	queue := &memoryBusQueue{name: name}
+	for i := 0; i < thread; i++ {
+		worker := &memoryBusWorker{}
+		worker.cond = sync.NewCond(&worker.mutex)
+		queue.workers = append(queue.workers, worker)
+	}
+	connect.queues[name] = queue
+	return nil
+}
+
+func (connect *memoryBusConnect) Start() error {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	if connect.running {
+		return nil
+	}
+	connect.running = true
+
+	for _, queue := range connect.queues {
+		for _, worker := range queue.workers {
+			connect.workers.Add(1)
+			go connect.working(queue.name, worker)
+		}
+	}
+	return nil
+}
+
+func (connect *memoryBusConnect) working(name string, worker *memoryBusWorker) {
+	defer connect.workers.Done()
+	for {
+		data, ok := worker.pop()
+		if !ok {
+			return
+		}
+		connect.queueHandler(name, data)
+	}
+}
+
+//延时的用定时器，关闭后不再投递
+func (connect *memoryBusConnect) delaying(delays []time.Duration, call func()) {
+	if len(delays) == 0 || delays[0] <= 0 {
+		call()
+		return
+	}
+
+	connect.mutex.RLock()
+	closing := connect.closing
+	connect.mutex.RUnlock()
+	if closing == nil {
+		return
+	}
+
+	timer := time.NewTimer(delays[0])
+	go func() {
+		select {
+		case <-closing:
+			timer.Stop()
+		case <-timer.C:
+			call()
+		}
+	}()
+}
+
+func (connect *memoryBusConnect) Publish(name string, data []byte, delays ...time.Duration) error {
+	connect.mutex.RLock()
+	_, ok := connect.events[name]
+	connect.mutex.RUnlock()
+
+	//没有订阅的事件直接丢掉
+	if !ok {
+		return nil
+	}
+	connect.delaying(delays, func() {
+		go connect.eventHandler(name, data)
+	})
+	return nil
+}
+
+func (connect *memoryBusConnect) Enqueue(name string, data []byte, delays ...time.Duration) error {
+	return connect.EnqueueKey(name, "", data, delays...)
+}
+
+//分区入队，key相同的进同一个协程，没有key的轮流分配
+func (connect *memoryBusConnect) EnqueueKey(name, key string, data []byte, delays ...time.Duration) error {
+	connect.mutex.RLock()
+	queue, ok := connect.queues[name]
+	connect.mutex.RUnlock()
+	if !ok {
+		return errors.New("队列未订阅：" + name)
+	}
+
+	worker := queue.workers[0]
+	if count := len(queue.workers); count > 1 {
+		if key != "" {
+			hash := fnv.New32a()
+			hash.Write([]byte(key))
+			worker = queue.workers[int(hash.Sum32()%uint32(count))]
+		} else {
+			worker = queue.workers[int(atomic.AddUint64(&queue.next, 1)%uint64(count))]
+		}
+	}
+
+	connect.delaying(delays, func() {
+		worker.push(data)
+	})
+	return nil
+}
+
+func (worker *memoryBusWorker) push(data []byte) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	if worker.closed {
+		return
+	}
+	worker.items = append(worker.items, data)
+	worker.cond.Signal()
+}
+
+func (worker *memoryBusWorker) pop() ([]byte, bool) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	for len(worker.items) == 0 && !worker.closed {
+		worker.cond.Wait()
+	}
+	if worker.closed {
+		return nil, false
+	}
+	data := worker.items[0]
+	worker.items[0] = nil
+	worker.items = worker.items[1:]
+	return data, true
+}
+
+func (worker *memoryBusWorker) close() {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	worker.closed = true
+	worker.items = nil
+	worker.cond.Broadcast()
+}
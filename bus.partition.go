@@ -0,0 +1,36 @@
+package ark
+
+import (
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//分区队列，EnqueueKey 带上分区key，由驱动保证同一个key的消息按顺序处理，不同的key并行
+//驱动实现了 BusPartitioner 的，同一个key进同一个消费者，比如内存和文件驱动
+//不支持的驱动按普通队列发送，和普通消息一样并行处理，不保证顺序
+//失败重试的消息重新入队，会排到同一个key后面的消息之后，需要严格顺序的不要开启重试
+
+type (
+	// BusPartitioner 驱动支持分区的，可以实现这个接口
+	BusPartitioner interface {
+		EnqueueKey(name, key string, data []byte, delays ...time.Duration) error
+	}
+)
+
+// EnqueueKey 按分区key发起队列
+func (module *busModule) EnqueueKey(name, key string, value Map, delays ...time.Duration) *Res {
+	return module.enqueueKey(nil, name, key, value, delays...)
+}
+func (module *busModule) enqueueKey(ctx *context, name, key string, value Map, delays ...time.Duration) *Res {
+	bus, done := module.sending(ctx, BusQueue, name, value, delays...)
+	defer done()
+	bus.Key = key
+	return module.filtering(bus, false, module.enqueueing)
+}
+
+//语法糖
+
+func EnqueueKey(name, key string, value Map, delays ...time.Duration) *Res {
+	return ark.Bus.EnqueueKey(name, key, value, delays...)
+}
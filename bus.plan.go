@@ -0,0 +1,526 @@
+package ark
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//计划在集群中只执行一次
+//每次触发时，各节点用计划名和触发时间去抢同一个锁，抢到的节点执行
+//锁不主动释放，到期自动删除，避免时钟有偏差的节点抢到已经释放的锁再执行一次
+//需要集群共享的互斥驱动，默认的内存驱动只在本进程内有效，相当于每个节点都执行
+//Plan.All 为 true 时不抢锁，每个节点都执行
+//暂停状态也保存在缓存中，在一个节点上暂停，整个集群都不执行
+//上一次还没执行完的判断也用锁，执行期间自动续期，All的计划每个节点单独判断
+//超时是协作式的，只会取消上下文，方法真正返回之前一直算在执行中，锁也一直持有
+//最后一次执行的时间保存在缓存中，补执行要靠它判断，只有redis这类外部缓存才能跨重启保留
+//调度和补执行在所有模块初始化以后才开始，见 launching
+
+const (
+	PlanSkip  = "skip"
+	PlanQueue = "queue"
+	PlanAllow = "allow"
+
+	planLockPrefix    = "$plan.lock."
+	planRunningPrefix = "$plan.running."
+	planRunPrefix     = "$plan.run."
+	planPausePrefix   = "$plan.pause."
+	planLockExpiry    = time.Minute
+)
+
+type (
+	// PlanRun 计划最后一次执行的记录
+	PlanRun struct {
+		Plan     string        `json:"plan"`
+		Node     int64         `json:"node"`
+		Start    time.Time     `json:"start"`
+		Duration time.Duration `json:"duration"`
+		Code     int           `json:"code"`
+		Result   string        `json:"result"`
+	}
+
+	// PlanInfo 计划的状态，给管理页面用
+	PlanInfo struct {
+		Name   string    `json:"name"`
+		Desc   string    `json:"desc"`
+		Method string    `json:"method"`
+		Times  []string  `json:"times"`
+		All    bool      `json:"all"`
+		Paused bool      `json:"paused"`
+		Next   time.Time `json:"next"`
+		Prev   time.Time `json:"prev"`
+		Last   *PlanRun  `json:"last,omitempty"`
+	}
+
+	//调度中的计划
+	planEntry struct {
+		name      string
+		config    Plan
+		schedules []*cronSchedule
+		next      time.Time
+	}
+)
+
+//下一次时间，多个表达式取最早的
+func (entry *planEntry) nexting(t time.Time) time.Time {
+	next := time.Time{}
+	for _, schedule := range entry.schedules {
+		if tt := schedule.Next(t); !tt.IsZero() && (next.IsZero() || tt.Before(next)) {
+			next = tt
+		}
+	}
+	return next
+}
+
+//上一次时间，多个表达式取最晚的
+func (entry *planEntry) preving(t time.Time) time.Time {
+	prev := time.Time{}
+	for _, schedule := range entry.schedules {
+		if tt := schedule.Prev(t); !tt.IsZero() && tt.After(prev) {
+			prev = tt
+		}
+	}
+	return prev
+}
+
+//加入调度，调用时要持有module.mutex
+func (module *busModule) plan(name string, config Plan) error {
+	entry := &planEntry{name: name, config: config}
+	for _, crontab := range config.Times {
+		schedule, err := cronParse(crontab)
+		if err != nil {
+			return err
+		}
+		entry.schedules = append(entry.schedules, schedule)
+	}
+	entry.next = entry.nexting(time.Now())
+	module.entries[name] = entry
+	module.wakeup()
+	return nil
+}
+
+//调度有变化，叫醒调度协程重新计算
+func (module *busModule) wakeup() {
+	if module.waking == nil {
+		return
+	}
+	select {
+	case module.waking <- true:
+	default:
+	}
+}
+
+//调度协程，等到最早的一个计划时间，触发所有到期的计划
+func (module *busModule) scheduling() {
+	module.mutex.Lock()
+	waking, closing := module.waking, module.closing
+	module.mutex.Unlock()
+
+	for {
+		module.mutex.Lock()
+		next := time.Time{}
+		for _, entry := range module.entries {
+			if !entry.next.IsZero() && (next.IsZero() || entry.next.Before(next)) {
+				next = entry.next
+			}
+		}
+		module.mutex.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			timeout = timer.C
+		}
+
+		select {
+		case <-closing:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-waking:
+			if timer != nil {
+				timer.Stop()
+			}
+		case now := <-timeout:
+			module.mutex.Lock()
+			for name, entry := range module.entries {
+				if entry.next.IsZero() || entry.next.After(now) {
+					continue
+				}
+				tick := entry.next
+				entry.next = entry.nexting(now)
+				go module.planning(name, entry.config, tick)
+			}
+			module.mutex.Unlock()
+		}
+	}
+}
+
+//计划到时间了
+func (module *busModule) planning(name string, config Plan, tick time.Time) {
+	logger := ark.Logger.Logger("bus").With(Map{"plan": name})
+
+	if module.pausing(name) {
+		logger.Debug("[总线]计划已暂停", "tick", tick)
+		return
+	}
+
+	if config.All == false {
+		key := fmt.Sprintf("%s%s.%d", planLockPrefix, name, tick.Unix())
+		if _, err := ark.Mutex.Lock(key, planLockExpiry); err != nil {
+			if errors.Is(err, ErrMutexLocked) {
+				logger.Debug("[总线]计划已由其它节点执行", "tick", tick)
+			} else {
+				logger.Warning("[总线]计划加锁失败", "tick", tick, "error", err)
+			}
+			return
+		}
+	}
+
+	//上一次还没执行完
+	if config.Overlap != PlanAllow {
+		key := planRunningPrefix + name
+		if config.All {
+			key = fmt.Sprintf("%s%s.%d", planRunningPrefix, name, ark.Config.Node.Id)
+		}
+
+		var lock *MutexLock
+		var err error
+		if config.Overlap == PlanQueue {
+			//最多等到下一次触发，不会越积越多
+			wait := planLockExpiry
+			module.mutex.Lock()
+			if entry, ok := module.entries[name]; ok {
+				if next := entry.nexting(tick); !next.IsZero() {
+					wait = time.Until(next)
+				}
+			}
+			module.mutex.Unlock()
+			lock, err = ark.Mutex.LockWait(gocontext.Background(), key, planLockExpiry, wait)
+		} else {
+			lock, err = ark.Mutex.Lock(key, planLockExpiry)
+		}
+		if err != nil {
+			logger.Warning("[总线]计划上一次还未执行完，跳过", "tick", tick, "overlap", config.Overlap, "error", err)
+			return
+		}
+		stop := lock.Watch()
+		defer func() {
+			stop()
+			lock.Unlock()
+		}()
+	}
+
+	//超时了也要等方法返回再解锁，不然下一次会和还在跑的这次重叠
+	_, finished := module.running(name, config, config.Value)
+	<-finished
+}
+
+//补执行停机期间错过的一次，从来没有执行过的不补
+//用错过的那次触发时间加锁，和正常触发一样集群中只执行一次
+func (module *busModule) catching(name string, config Plan, tick time.Time) {
+	if tick.IsZero() {
+		return
+	}
+	last, ok := module.LastRun(name)
+	if !ok || !tick.After(last.Start) {
+		return
+	}
+	ark.Logger.Logger("bus").Info("[总线]计划补执行", "plan", name, "tick", tick, "last", last.Start)
+	module.planning(name, config, tick)
+}
+
+//执行计划并记录，超时的时候取消，不等方法返回
+//返回的通道在方法真正返回后关闭
+func (module *busModule) running(name string, config Plan, value Map) (*Res, <-chan bool) {
+	logger := ark.Logger.Logger("bus").With(Map{"plan": name})
+
+	ctx := newcontext()
+	base, cancel := gocontext.WithCancel(gocontext.Background())
+	if config.Timeout > 0 {
+		base, cancel = gocontext.WithTimeout(gocontext.Background(), config.Timeout)
+	}
+	defer cancel()
+	ctx.base = base
+	trace := ctx.TraceId()
+
+	start := time.Now()
+	done := make(chan *Res, 1)
+	finished := make(chan bool)
+	go func() {
+		defer close(finished)
+		//方法返回后才关闭数据库
+		defer ctx.terminal()
+		_, res := ark.Service.Invoke(ctx, config.Method, value)
+		done <- res
+	}()
+
+	var res *Res
+	timeout := false
+	select {
+	case res = <-done:
+	case <-base.Done():
+		timeout = true
+		res = Wrap(Fail, base.Err())
+	}
+
+	run := PlanRun{
+		Plan: name, Node: ark.Config.Node.Id, Start: start,
+		Duration: time.Since(start), Result: "ok",
+	}
+	if res != nil {
+		run.Code = ark.Basic.Code(res.Text, res.Code)
+		run.Result = res.Text
+	}
+	if timeout {
+		run.Result = "timeout"
+	}
+	module.recording(run)
+
+	if timeout {
+		logger.Warning("[总线]计划执行超时", "trace", trace, "timeout", config.Timeout.String())
+		go func() {
+			<-finished
+			logger.Warning("[总线]计划超时后执行结束", "trace", trace, "duration", time.Since(start).String())
+		}()
+	} else if res != nil && res.Fail() {
+		logger.Warning("[总线]计划执行失败", "trace", trace, "result", res.Text, "duration", run.Duration.String())
+	} else {
+		logger.Debug("[总线]计划执行完成", "trace", trace, "duration", run.Duration.String())
+	}
+	return res, finished
+}
+
+//保存执行记录，本地一份，缓存中一份给其它节点看
+func (module *busModule) recording(run PlanRun) {
+	module.mutex.Lock()
+	module.runs[run.Plan] = run
+	module.mutex.Unlock()
+
+	data, err := ark.Codec.Marshal(run)
+	if err == nil {
+		err = ark.Cache.Write(planRunPrefix+run.Plan, string(data), 0)
+	}
+	if err != nil {
+		ark.Logger.Logger("bus").Debug("[总线]保存计划记录失败", "plan", run.Plan, "error", err)
+	}
+}
+
+// LastRun 计划最后一次执行的记录，优先读缓存中集群的记录
+func (module *busModule) LastRun(name string) (PlanRun, bool) {
+	if value, err := ark.Cache.Read(planRunPrefix + name); err == nil && value != nil {
+		var data []byte
+		switch vv := value.(type) {
+		case string:
+			data = []byte(vv)
+		case []byte:
+			data = vv
+		}
+		run := PlanRun{}
+		if data != nil && ark.Codec.Unmarshal(data, &run) == nil {
+			return run, true
+		}
+	}
+
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+	run, ok := module.runs[name]
+	return run, ok
+}
+
+//是否暂停，本地没有暂停的，再看缓存中其它节点的设置
+func (module *busModule) pausing(name string) bool {
+	module.mutex.Lock()
+	paused := module.paused[name]
+	module.mutex.Unlock()
+	if paused {
+		return true
+	}
+	ok, err := ark.Cache.Exists(planPausePrefix + name)
+	return err == nil && ok
+}
+
+// Plans 所有计划的状态，按名称排序
+func (module *busModule) Plans() []PlanInfo {
+	now := time.Now()
+
+	module.mutex.Lock()
+	infos := make([]PlanInfo, 0, len(module.plans))
+	for name, config := range module.plans {
+		info := PlanInfo{
+			Name: name, Desc: config.Desc, Method: config.Method,
+			Times: append([]string{}, config.Times...), All: config.All,
+		}
+		if entry, ok := module.entries[name]; ok {
+			info.Next = entry.next
+			info.Prev = entry.preving(now)
+		}
+		infos = append(infos, info)
+	}
+	module.mutex.Unlock()
+
+	for i := range infos {
+		infos[i].Paused = module.pausing(infos[i].Name)
+		if run, ok := module.LastRun(infos[i].Name); ok {
+			infos[i].Last = &run
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// PausePlan 暂停计划，整个集群生效，直到ResumePlan
+func (module *busModule) PausePlan(name string) error {
+	module.mutex.Lock()
+	if _, ok := module.plans[name]; !ok {
+		module.mutex.Unlock()
+		return errors.New("计划不存在：" + name)
+	}
+	module.paused[name] = true
+	module.mutex.Unlock()
+
+	//没有缓存的时候只在本节点暂停
+	if err := ark.Cache.Write(planPausePrefix+name, "1", 0); err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]计划暂停只在本节点生效", "plan", name, "error", err)
+	}
+	return nil
+}
+
+// ResumePlan 恢复计划
+func (module *busModule) ResumePlan(name string) error {
+	module.mutex.Lock()
+	if _, ok := module.plans[name]; !ok {
+		module.mutex.Unlock()
+		return errors.New("计划不存在：" + name)
+	}
+	delete(module.paused, name)
+	module.mutex.Unlock()
+
+	if err := ark.Cache.Delete(planPausePrefix + name); err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]计划恢复只在本节点生效", "plan", name, "error", err)
+	}
+	return nil
+}
+
+// RunPlan 在本节点立即执行一次计划，不加锁，暂停的也可以执行
+// 不传value时使用计划配置的值
+func (module *busModule) RunPlan(name string, values ...Map) *Res {
+	module.mutex.Lock()
+	config, ok := module.plans[name]
+	module.mutex.Unlock()
+	if !ok {
+		return errResult(errors.New("计划不存在：" + name))
+	}
+
+	value := config.Value
+	if len(values) > 0 && values[0] != nil {
+		value = values[0]
+	}
+	res, _ := module.running(name, config, value)
+	return res
+}
+
+// AddPlanTime 运行时给计划加上时间表达式
+func (module *busModule) AddPlanTime(name string, times ...string) error {
+	for _, crontab := range times {
+		if _, err := cronParse(crontab); err != nil {
+			return err
+		}
+	}
+
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	config, ok := module.plans[name]
+	if !ok {
+		return errors.New("计划不存在：" + name)
+	}
+	config.Times = append(append([]string{}, config.Times...), times...)
+	module.plans[name] = config
+
+	if module.closing != nil {
+		return module.plan(name, config)
+	}
+	return nil
+}
+
+// RemovePlanTime 运行时去掉计划的时间表达式，全部去掉以后计划不再触发
+func (module *busModule) RemovePlanTime(name string, times ...string) error {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	config, ok := module.plans[name]
+	if !ok {
+		return errors.New("计划不存在：" + name)
+	}
+	removes := map[string]bool{}
+	for _, crontab := range times {
+		removes[crontab] = true
+	}
+	keeps := []string{}
+	for _, crontab := range config.Times {
+		if !removes[crontab] {
+			keeps = append(keeps, crontab)
+		}
+	}
+	config.Times = keeps
+	module.plans[name] = config
+
+	if module.closing != nil {
+		return module.plan(name, config)
+	}
+	return nil
+}
+
+// RemovePlan 运行时删除计划，注册的方法保留
+func (module *busModule) RemovePlan(name string) error {
+	module.mutex.Lock()
+	defer module.mutex.Unlock()
+
+	if _, ok := module.plans[name]; !ok {
+		return errors.New("计划不存在：" + name)
+	}
+	delete(module.plans, name)
+	delete(module.entries, name)
+	delete(module.paused, name)
+	module.wakeup()
+	return nil
+}
+
+//语法糖
+
+func Plans() []PlanInfo {
+	return ark.Bus.Plans()
+}
+func PausePlan(name string) error {
+	return ark.Bus.PausePlan(name)
+}
+func ResumePlan(name string) error {
+	return ark.Bus.ResumePlan(name)
+}
+func RunPlan(name string, values ...Map) *Res {
+	return ark.Bus.RunPlan(name, values...)
+}
+func AddPlanTime(name string, times ...string) error {
+	return ark.Bus.AddPlanTime(name, times...)
+}
+func RemovePlanTime(name string, times ...string) error {
+	return ark.Bus.RemovePlanTime(name, times...)
+}
+func RemovePlan(name string) error {
+	return ark.Bus.RemovePlan(name)
+}
+
+func LastPlanRun(name string) (PlanRun, bool) {
+	return ark.Bus.LastRun(name)
+}
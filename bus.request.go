@@ -0,0 +1,199 @@
+package ark
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//总线上的请求回复
+//请求按队列发出，带上本节点的回复地址，消费的节点执行方法后，把数据和结果发到回复地址
+//回复用请求的id对应，超时没有回复的返回 Retry
+//回复地址是 $reply.<节点id>，只会回复到这个前缀下的队列，不能借回复往业务队列里发消息
+//驱动实现了 BusRequester 的，直接用驱动的请求回复，不走回复队列
+
+const (
+	busReplyPrefix = "$reply."
+)
+
+type (
+	// RequestHandler 请求回调，返回回复的数据
+	RequestHandler func(string, []byte) ([]byte, error)
+
+	// BusRequester 驱动原生支持请求回复的，可以实现这个接口
+	BusRequester interface {
+		AcceptRequest(RequestHandler) error
+		Request(name string, data []byte, timeout time.Duration) ([]byte, error)
+	}
+)
+
+//执行请求，生成回复的信封
+func (module *busModule) invoking(name string, envelope busEnvelope) busEnvelope {
+	ctx := module.contexting(name, envelope)
+	defer ctx.terminal()
+
+	data, res := module.consuming(ctx, BusRequest, name, envelope.Value)
+
+	reply := busEnvelope{
+		Version: busVersion, Id: ark.Codec.Unique(), Time: time.Now(),
+		Node: ark.Config.Node.Id, Type: busContentType, Trace: envelope.Trace,
+		Correlation: envelope.Id, Value: data,
+	}
+	if res != nil {
+		reply.Code = res.Code
+		reply.State = res.Text
+		reply.Args = uncaused(res.Args)
+	}
+	return reply
+}
+
+//收到请求，执行后发到回复地址
+func (module *busModule) responding(name string, envelope busEnvelope) {
+	if !strings.HasPrefix(envelope.Reply, busReplyPrefix) {
+		ark.Logger.Logger("bus").Warning("[总线]无效的回复地址", "queue", name, "reply", envelope.Reply, "trace", envelope.Trace)
+		return
+	}
+
+	reply := module.invoking(name, envelope)
+
+	data, err := ark.Codec.Marshal(reply)
+	if err == nil {
+		err = module.enqueuing(envelope.Reply, "", data)
+	}
+	if err != nil {
+		ark.Logger.Logger("bus").Warning("[总线]回复失败", "queue", name, "trace", envelope.Trace, "error", err)
+	}
+}
+
+//驱动原生的请求
+func (module *busModule) requesting(name string, data []byte) ([]byte, error) {
+	envelope, err := module.decoding(data)
+	if err != nil {
+		return nil, err
+	}
+	return ark.Codec.Marshal(module.invoking(name, envelope))
+}
+
+//收到回复，交给等待中的请求，已经超时的丢掉
+func (module *busModule) replied(envelope busEnvelope) {
+	module.replyMutex.Lock()
+	waiter, ok := module.replies[envelope.Correlation]
+	delete(module.replies, envelope.Correlation)
+	module.replyMutex.Unlock()
+
+	if ok {
+		waiter <- envelope
+	} else {
+		ark.Logger.Logger("bus").Debug("[总线]回复已超时", "correlation", envelope.Correlation, "trace", envelope.Trace)
+	}
+}
+
+//回复转成结果
+func (module *busModule) replyResult(reply busEnvelope) (Map, *Res) {
+	var res *Res
+	if reply.State != "" {
+		res = codeResult(reply.Code, reply.State, reply.Args...)
+	}
+	if reply.Value == nil {
+		reply.Value = Map{}
+	}
+	return reply.Value, res
+}
+
+// Request 发起请求并等待回复
+func (module *busModule) Request(name string, value Map, timeout time.Duration) (Map, *Res) {
+	return module.request(nil, name, value, timeout)
+}
+func (module *busModule) request(ctx *context, name string, value Map, timeout time.Duration) (Map, *Res) {
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+
+	bus, done := module.sending(ctx, BusRequest, name, value)
+	defer done()
+
+	var data Map
+	res := module.filtering(bus, false, func(bus *BusContext) *Res {
+		var res *Res
+		data, res = module.calling(bus, timeout)
+		return res
+	})
+	return data, res
+}
+
+//实际发出请求，在拦截器之后
+func (module *busModule) calling(bus *BusContext, timeout time.Duration) (Map, *Res) {
+	name := bus.Name
+	locate := module.queueLocate(name)
+	if config, ok := module.queues[name]; ok {
+		vvv, res := module.validating(bus.context, locate, config.Args, config.Nullable, config.Setting, bus.Value)
+		if res != nil {
+			return nil, res
+		}
+		bus.Value = vvv
+	}
+
+	envelope := busEnvelope{
+		Version: busVersion, Id: ark.Codec.Unique(), Time: time.Now(),
+		Node: ark.Config.Node.Id, Type: busContentType, Trace: bus.TraceId(),
+		Value: bus.Value, Reply: module.reply,
+	}
+	if len(bus.Headers) > 0 {
+		envelope.Headers = bus.Headers
+	}
+	data, err := ark.Codec.Marshal(envelope)
+	if err != nil {
+		return nil, errResult(err)
+	}
+
+	connect, ok := module.connects[locate]
+	if !ok {
+		return nil, errResult(errors.New("请求失败"))
+	}
+
+	//驱动原生支持的
+	if requester, ok := connect.(BusRequester); ok {
+		bytes, err := requester.Request(name, data, timeout)
+		if err != nil {
+			return nil, Wrap(Retry, err)
+		}
+		reply, err := module.decoding(bytes)
+		if err != nil {
+			return nil, errResult(err)
+		}
+		return module.replyResult(reply)
+	}
+
+	waiter := make(chan busEnvelope, 1)
+	module.replyMutex.Lock()
+	module.replies[envelope.Id] = waiter
+	module.replyMutex.Unlock()
+
+	if err := connect.Enqueue(name, data); err != nil {
+		module.replyMutex.Lock()
+		delete(module.replies, envelope.Id)
+		module.replyMutex.Unlock()
+		return nil, errResult(err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-waiter:
+		return module.replyResult(reply)
+	case <-timer.C:
+		module.replyMutex.Lock()
+		delete(module.replies, envelope.Id)
+		module.replyMutex.Unlock()
+		return nil, Wrap(Retry, errors.New("请求超时"))
+	}
+}
+
+//语法糖
+
+func Request(name string, value Map, timeout time.Duration) (Map, *Res) {
+	return ark.Bus.Request(name, value, timeout)
+}
@@ -1,6 +1,7 @@
 package ark
 
 import (
+	gocontext "context"
 	"time"
 
 	. "github.com/arkgo/asset"
@@ -20,6 +21,15 @@ type (
 		zone      *time.Location
 		lastError *Res
 		databases map[string]DataBase
+
+		//跟踪id，同一个请求中的日志、服务调用、总线消息共用
+		trace string
+
+		//总线消息的元数据，只有事件和队列的处理中有
+		message *Message
+
+		//取消信号，比如计划超时
+		base gocontext.Context
 	}
 )
 
@@ -54,6 +64,37 @@ func (ctx *context) Zone(zones ...*time.Location) *time.Location {
 	return ctx.zone
 }
 
+// TraceId 获取或设置当前上下文的跟踪id，没有的时候自动生成
+func (ctx *context) TraceId(ids ...string) string {
+	if ctx == nil {
+		return ""
+	}
+	if len(ids) > 0 && ids[0] != "" {
+		ctx.trace = ids[0]
+	}
+	if ctx.trace == "" {
+		ctx.trace = ark.Codec.Unique()
+	}
+	return ctx.trace
+}
+
+// Context 取消信号，超时或取消时Done会关闭，没有设置的永远不会取消
+func (ctx *context) Context() gocontext.Context {
+	if ctx == nil || ctx.base == nil {
+		return gocontext.Background()
+	}
+	return ctx.base
+}
+
+// Logger 带跟踪id的日志，可以指定日志名称
+func (ctx *context) Logger(names ...string) *Logging {
+	name := ""
+	if len(names) > 0 {
+		name = names[0]
+	}
+	return ark.Logger.Logger(name).With(Map{"trace": ctx.TraceId()})
+}
+
 //最终的清理工作
 func (ctx *context) terminal() {
 	for _, base := range ctx.databases {
@@ -72,7 +113,7 @@ func (ctx *context) dataBase(bases ...string) DataBase {
 		}
 	}
 	if _, ok := ctx.databases[base]; ok == false {
-		ctx.databases[base] = ark.Data.Base(base)
+		ctx.databases[base] = ark.Data.outboxBase(base, ark.Data.Base(base))
 	}
 	return ctx.databases[base]
 }
@@ -163,13 +204,33 @@ func (ctx *context) Logic(name string, settings ...Map) *Logic {
 
 //------- 服务调用 end-----------------
 
+// Publish 发起事件，带上当前的跟踪id
+func (ctx *context) Publish(name string, value Map, delays ...time.Duration) *Res {
+	return ark.Bus.publish(ctx, name, value, delays...)
+}
+
+// Enqueue 发起队列，带上当前的跟踪id
+func (ctx *context) Enqueue(name string, value Map, delays ...time.Duration) *Res {
+	return ark.Bus.enqueue(ctx, name, value, delays...)
+}
+
+// EnqueueKey 按分区key发起队列，带上当前的跟踪id
+func (ctx *context) EnqueueKey(name, key string, value Map, delays ...time.Duration) *Res {
+	return ark.Bus.enqueueKey(ctx, name, key, value, delays...)
+}
+
+// Request 发起请求并等待回复，带上当前的跟踪id
+func (ctx *context) Request(name string, value Map, timeout time.Duration) (Map, *Res) {
+	return ark.Bus.request(ctx, name, value, timeout)
+}
+
 //语法糖
 func (ctx *context) Locked(key string, expiry time.Duration, cons ...string) bool {
-	return ark.Mutex.Lock(key, expiry, cons...) != nil
+	return Locked(key, expiry, cons...)
 }
-func (ctx *context) Lock(key string, expiry time.Duration, cons ...string) error {
+func (ctx *context) Lock(key string, expiry time.Duration, cons ...string) (*MutexLock, error) {
 	return ark.Mutex.Lock(key, expiry, cons...)
 }
-func (ctx *context) Unlock(key string, cons ...string) error {
-	return ark.Mutex.Unlock(key, cons...)
+func (ctx *context) Unlock(lock *MutexLock) error {
+	return ark.Mutex.Unlock(lock)
 }
@@ -111,6 +111,11 @@ type (
 		connects map[string]DataConnect
 		weights  map[string]int
 		hashring *hashring.HashRing
+
+		//发件箱投递
+		outboxGroup   sync.WaitGroup
+		outboxWakings map[string]chan bool
+		outboxClosing chan bool
 	}
 
 	dataGroup struct {
@@ -209,10 +214,13 @@ func (module *dataModule) initing() {
 	//hashring分片
 	module.weights = weights
 	module.hashring = hashring.New(weights)
+
+	module.outboxing()
 }
 
 //退出
 func (module *dataModule) exiting() {
+	module.outboxExiting()
 	for _, connect := range module.connects {
 		connect.Close()
 	}
@@ -1038,6 +1046,7 @@ func ParseSQL(args ...Any) (string, []Any, string, error) {
 func DataSerial(key string, start, step int64, cons ...string) int64 {
 	num, err := ark.Cache.Serial(key, start, step, cons...)
 	if err != nil {
+		ark.Logger.Logger("data").Warning("[数据]生成序列失败", "key", key, "error", err)
 		return int64(0)
 	}
 	return num
@@ -0,0 +1,304 @@
+package ark
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	. "github.com/arkgo/asset"
+	"github.com/arkgo/asset/util"
+)
+
+//事务发件箱，事务中发起的事件和队列先写到发件箱表，和数据在同一个事务里
+//提交以后由后台投递到总线，投递成功后删除，至少投递一次，消费端要能处理重复
+//只有用上下文发起的才会进发件箱，比如 p.Publish、ctx.Enqueue，全局的Publish不知道当前事务
+//[data.main.setting]
+//outbox = true   #或是表名，默认 ark_outbox
+//relay = "5s"    #定时投递的间隔，提交后会马上投递一次
+//
+//表结构，以postgres为例
+//CREATE TABLE ark_outbox (
+//	id      BIGSERIAL PRIMARY KEY,
+//	kind    VARCHAR(10) NOT NULL,
+//	name    VARCHAR(200) NOT NULL,
+//	data    TEXT NOT NULL,
+//	delay   BIGINT NOT NULL DEFAULT 0,
+//	created TIMESTAMP NOT NULL
+//);
+
+const (
+	dataOutboxTable  = "ark_outbox"
+	dataOutboxBatch  = 100
+	dataOutboxRelay  = time.Second * 5
+	dataOutboxPrefix = "$outbox."
+
+	busOutboxEvent = "event"
+	busOutboxQueue = "queue"
+)
+
+type (
+	//包装上下文中的数据库，记录是否在事务中
+	dataOutbox struct {
+		DataBase
+		name    string
+		table   string
+		begun   bool
+		written bool
+	}
+)
+
+//开启发件箱的库，返回表名
+func (module *dataModule) outboxTable(name string) string {
+	config, ok := ark.Config.Data[name]
+	if !ok {
+		return ""
+	}
+	switch vv := config.Setting["outbox"].(type) {
+	case bool:
+		if vv {
+			return dataOutboxTable
+		}
+	case string:
+		return vv
+	}
+	return ""
+}
+
+//包装数据库，没有开启发件箱的原样返回
+func (module *dataModule) outboxBase(name string, base DataBase) DataBase {
+	table := module.outboxTable(name)
+	if table == "" {
+		return base
+	}
+	return &dataOutbox{DataBase: base, name: name, table: table}
+}
+
+func (base *dataOutbox) Begin() (*sql.Tx, error) {
+	tx, err := base.DataBase.Begin()
+	if err == nil {
+		base.begun = true
+		base.written = false
+	}
+	return tx, err
+}
+
+//提交后叫醒投递
+func (base *dataOutbox) Submit() error {
+	err := base.DataBase.Submit()
+	written := base.written
+	base.begun, base.written = false, false
+	if err == nil && written {
+		ark.Data.outboxWakeup(base.name)
+	}
+	return err
+}
+
+func (base *dataOutbox) Cancel() error {
+	base.begun, base.written = false, false
+	return base.DataBase.Cancel()
+}
+
+//写到发件箱，和当前事务一起提交
+func (base *dataOutbox) outbox(kind, name string, data []byte, delays ...time.Duration) error {
+	delay := time.Duration(0)
+	if len(delays) > 0 {
+		delay = delays[0]
+	}
+	item := base.Table(base.table).Create(Map{
+		"kind": kind, "name": name, "data": string(data),
+		"delay": delay.Milliseconds(), "created": time.Now(),
+	})
+	if err := base.Erred(); err != nil {
+		return err
+	}
+	if item == nil {
+		return errors.New("写入发件箱失败")
+	}
+	base.written = true
+	return nil
+}
+
+//当前上下文中开启了事务的发件箱，按库名取第一个
+func (ctx *context) outbox() *dataOutbox {
+	if ctx == nil {
+		return nil
+	}
+	names := []string{}
+	for name, base := range ctx.databases {
+		if vv, ok := base.(*dataOutbox); ok && vv.begun {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return ctx.databases[names[0]].(*dataOutbox)
+}
+
+//------------ relay ----------------
+
+//开始投递，每个开启了发件箱的库一个协程
+func (module *dataModule) outboxing() {
+	module.outboxWakings = make(map[string]chan bool)
+	module.outboxClosing = make(chan bool)
+
+	for name := range module.connects {
+		table := module.outboxTable(name)
+		if table == "" {
+			continue
+		}
+		relay := dataOutboxRelay
+		if vv, ok := ark.Config.Data[name].Setting["relay"].(string); ok && vv != "" {
+			td, err := util.ParseDuration(vv)
+			if err != nil {
+				panic("[数据]无效的发件箱投递间隔：" + vv)
+			}
+			relay = td
+		}
+
+		waking := make(chan bool, 1)
+		module.outboxWakings[name] = waking
+
+		module.outboxGroup.Add(1)
+		go module.relaying(name, table, relay, waking)
+	}
+}
+
+func (module *dataModule) outboxWakeup(name string) {
+	waking, ok := module.outboxWakings[name]
+	if !ok {
+		return
+	}
+	select {
+	case waking <- true:
+	default:
+	}
+}
+
+func (module *dataModule) outboxExiting() {
+	if module.outboxClosing != nil {
+		close(module.outboxClosing)
+		module.outboxGroup.Wait()
+		module.outboxClosing = nil
+	}
+}
+
+func (module *dataModule) relaying(name, table string, relay time.Duration, waking chan bool) {
+	defer module.outboxGroup.Done()
+
+	ticker := time.NewTicker(relay)
+	defer ticker.Stop()
+
+	for {
+		module.relay(name, table)
+
+		select {
+		case <-module.outboxClosing:
+			return
+		case <-waking:
+		case <-ticker.C:
+		}
+	}
+}
+
+//投递一个库的发件箱，多个节点用锁避免同时投递同一批
+func (module *dataModule) relay(name, table string) {
+	logger := ark.Logger.Logger("data").With(Map{"base": name})
+
+	lock, err := ark.Mutex.Lock(dataOutboxPrefix+name, time.Minute)
+	if err != nil {
+		if !errors.Is(err, ErrMutexLocked) {
+			logger.Warning("[数据]发件箱加锁失败", "error", err)
+		}
+		return
+	}
+	stop := lock.Watch()
+	defer func() {
+		stop()
+		lock.Unlock()
+	}()
+
+	base := module.Base(name)
+	defer base.Close()
+
+	for {
+		_, items := base.Table(table).Limit(0, dataOutboxBatch, Map{"id": ASC})
+		if err := base.Erred(); err != nil {
+			logger.Warning("[数据]读取发件箱失败", "error", err)
+			return
+		}
+
+		for _, item := range items {
+			//锁丢了别的节点可能已经在投递，停下
+			select {
+			case <-lock.Lost():
+				logger.Warning("[数据]发件箱锁已丢失，停止投递")
+				return
+			default:
+			}
+
+			kind, _ := item["kind"].(string)
+			msg, _ := item["name"].(string)
+			data, _ := item["data"].(string)
+
+			delays := []time.Duration{}
+			if delay := dataOutboxDelay(item); delay > 0 {
+				delays = append(delays, delay)
+			}
+
+			if err := ark.Bus.delivering(kind, msg, []byte(data), delays...); err != nil {
+				//投递失败的留着下次再投，后面的也不投，保持顺序
+				logger.Warning("[数据]发件箱投递失败", "kind", kind, "name", msg, "error", err)
+				return
+			}
+
+			base.Table(table).Delete(Map{"id": item["id"]})
+			if err := base.Erred(); err != nil {
+				logger.Warning("[数据]发件箱清理失败", "id", item["id"], "error", err)
+				return
+			}
+		}
+
+		if len(items) < dataOutboxBatch {
+			return
+		}
+	}
+}
+
+//剩余的延时，写入以后已经等过的要扣掉
+func dataOutboxDelay(item Map) time.Duration {
+	delay := time.Duration(0)
+	switch vv := item["delay"].(type) {
+	case int64:
+		delay = time.Duration(vv) * time.Millisecond
+	case int:
+		delay = time.Duration(vv) * time.Millisecond
+	case float64:
+		delay = time.Duration(vv) * time.Millisecond
+	}
+	if created, ok := item["created"].(time.Time); ok {
+		delay -= time.Since(created)
+	}
+	return delay
+}
+
+//投递已编码好的消息
+func (module *busModule) delivering(kind, name string, data []byte, delays ...time.Duration) error {
+	switch kind {
+	case busOutboxEvent:
+		if connect, ok := module.connects[module.eventLocate(name)]; ok {
+			return connect.Publish(name, data, delays...)
+		}
+		return errors.New("发布失败")
+	case busOutboxQueue:
+		//分区key在信封里
+		key := ""
+		if envelope, err := module.decoding(data); err == nil {
+			key = envelope.Key
+		}
+		return module.enqueuing(name, key, data, delays...)
+	}
+	return errors.New("未知的消息类型：" + kind)
+}
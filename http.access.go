@@ -0,0 +1,218 @@
+package ark
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/arkgo/asset"
+	"github.com/arkgo/asset/util"
+)
+
+//访问日志，写到名为 access 的日志，可以用 [logger.xxx] 的 names 配置专门的输出
+//[http.setting.access]
+//format = "combined"  #combined、json，或是模板 "{ip} {method} {uri} {status} {bytes} {latency}"
+//exclude = ["/health", "/static/*"]
+//slow = "1s"
+//站点可以单独关闭，[site.xxx.setting] access = false
+
+const (
+	httpAccessLogger = "access"
+)
+
+type (
+	httpAccess struct {
+		format   string
+		excludes []string
+		slow     time.Duration
+	}
+
+	//记录状态码和字节数
+	httpWriter struct {
+		http.ResponseWriter
+		status int
+		bytes  int64
+	}
+)
+
+func (writer *httpWriter) WriteHeader(status int) {
+	if writer.status == 0 {
+		writer.status = status
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *httpWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	n, err := writer.ResponseWriter.Write(data)
+	writer.bytes += int64(n)
+	return n, err
+}
+
+func (writer *httpWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (writer *httpWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := writer.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("not hijacker")
+}
+
+func (writer *httpWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := writer.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+//发文件的时候底层可以用sendfile，字节数照样统计
+func (writer *httpWriter) ReadFrom(reader io.Reader) (int64, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if from, ok := writer.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = from.ReadFrom(reader)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{writer.ResponseWriter}, reader)
+	}
+	writer.bytes += n
+	return n, err
+}
+
+//给 http.ResponseController 用
+func (writer *httpWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+//解析访问日志配置，没有配置就不记录
+func (module *httpModule) accessing() *httpAccess {
+	config, ok := ark.Config.Http.Setting["access"].(Map)
+	if !ok {
+		return nil
+	}
+
+	access := &httpAccess{format: "combined", excludes: []string{}}
+	if vv, ok := config["format"].(string); ok && vv != "" {
+		access.format = vv
+	}
+	if vv, ok := config["exclude"].(string); ok && vv != "" {
+		access.excludes = append(access.excludes, vv)
+	}
+	if vvs, ok := config["exclude"].([]Any); ok {
+		for _, vv := range vvs {
+			if s, ok := vv.(string); ok && s != "" {
+				access.excludes = append(access.excludes, s)
+			}
+		}
+	}
+	if vv, ok := config["slow"].(string); ok && vv != "" {
+		td, err := util.ParseDuration(vv)
+		if err != nil {
+			panic("[HTTP]无效的慢请求时间：" + vv)
+		}
+		access.slow = td
+	}
+
+	return access
+}
+
+//路径排除，支持 path.Match 的通配，以 /* 结尾的匹配所有子路径
+func (access *httpAccess) excluded(uri string) bool {
+	for _, pattern := range access.excludes {
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(uri, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, uri); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//记录访问日志
+func (module *httpModule) accessLog(ctx *Http, started time.Time) {
+	access := module.access
+	if access == nil || access.excluded(ctx.Path) {
+		return
+	}
+	if vv, ok := ctx.siteConfig.Setting["access"].(bool); ok && vv == false {
+		return
+	}
+
+	latency := time.Since(started)
+	level := LevelInfo
+	slow := access.slow > 0 && latency >= access.slow
+	if slow {
+		level = LevelWarning
+	}
+
+	logger := ark.Logger.Logger(httpAccessLogger)
+	if !logger.Enabled(level) {
+		return
+	}
+
+	status, bytes := ctx.Code, int64(0)
+	if writer, ok := ctx.response.(*httpWriter); ok {
+		if writer.status > 0 {
+			status = writer.status
+		}
+		bytes = writer.bytes
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	values := Map{
+		"ip": ctx.Ip(), "host": ctx.Host, "site": ctx.Site, "name": ctx.Name,
+		"method": ctx.Method, "uri": ctx.Uri, "path": ctx.Path, "proto": ctx.request.Proto,
+		"status": status, "bytes": bytes, "latency": latency.String(),
+		"referer": ctx.request.Referer(), "agent": ctx.UserAgent(),
+		"trace": ctx.TraceId(), "time": started.Format("02/Jan/2006:15:04:05 -0700"),
+	}
+
+	msg := ""
+	fields := Map{}
+	switch access.format {
+	case "combined":
+		msg = fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d %q %q`,
+			values["ip"], values["time"], ctx.Method, ctx.Uri, values["proto"],
+			status, bytes, values["referer"], values["agent"])
+		if slow {
+			msg += " slow=" + latency.String()
+		}
+	case "json":
+		msg = httpAccessLogger
+		delete(values, "time")
+		values["latency"] = latency.Milliseconds()
+		values["slow"] = slow
+		fields = values
+	default:
+		msg = access.format
+		for key, val := range values {
+			msg = strings.Replace(msg, "{"+key+"}", fmt.Sprintf("%v", val), -1)
+		}
+		if slow {
+			msg += " slow"
+		}
+	}
+
+	if slow {
+		logger.Warning(msg, fields)
+	} else {
+		logger.Info(msg, fields)
+	}
+}
@@ -1,6 +1,7 @@
 package ark
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/xml"
@@ -93,7 +94,7 @@ func httpContext(thread HttpThread) *Http {
 	ctx := &Http{
 		context: newcontext(),
 		index:   0, nexts: make([]HttpFunc, 0), charset: UTF8,
-		thread: thread, request: thread.Request(), response: thread.Response(),
+		thread: thread, request: thread.Request(), response: &httpWriter{ResponseWriter: thread.Response()},
 		Setting: make(Map),
 		headers: make(map[string]string), cookies: make(map[string]http.Cookie), sessions: make(Map),
 		Client: make(Map), Params: make(Map), Query: make(Map), Form: make(Map), Upload: make(Map), Data: make(Map),
@@ -393,8 +394,9 @@ func (ctx *Http) formUploadHandler(values []string) []Map {
 				if _, err := h.Write(baseBytes); err == nil {
 					hash := fmt.Sprintf("%x", h.Sum(nil))
 
-					mimeType := arr[1]
-					extension := ark.Basic.Extension(mimeType)
+					//不信任客户端提供的类型，按内容嗅探
+					sniff := ark.Basic.Sniff(bytes.NewReader(baseBytes))
+					mimeType, extension := ark.Basic.sniffing(sniff, "", arr[1])
 					filename := fmt.Sprintf("%s.%s", hash, extension)
 					length := len(baseBytes)
 
@@ -592,6 +594,10 @@ func (ctx *Http) formHandler() *Res {
 						//先计算hash
 						if file, err := f.Open(); err == nil {
 
+							//不信任客户端提供的类型和扩展名，按内容嗅探
+							sniff := ark.Basic.Sniff(file)
+							mimetype, extension = ark.Basic.sniffing(sniff, extension, mimetype)
+
 							h := sha1.New()
 							if _, err := io.Copy(h, file); err == nil {
 
@@ -1461,11 +1467,7 @@ func (ctx *Http) Alert(res *Res, urls ...string) {
 	// code := ark.Basic.Code(res.Text, res.Code)
 	text := ctx.String(res.Text, res.Args...)
 
-	if res.OK() {
-		ctx.Code = http.StatusOK
-	} else {
-		ctx.Code = http.StatusInternalServerError
-	}
+	ctx.Code = ctx.status(res)
 
 	if len(urls) > 0 {
 		text = fmt.Sprintf(`<script type="text/javascript">alert("%s"); location.href="%s";</script>`, text, urls[0])
@@ -1480,11 +1482,7 @@ func (ctx *Http) Show(res *Res, urls ...string) {
 	code := ark.Basic.Code(res.Text, res.Code)
 	text := ctx.String(res.Text, res.Args...)
 
-	if res.OK() {
-		ctx.Code = http.StatusOK
-	} else {
-		ctx.Code = http.StatusInternalServerError
-	}
+	ctx.Code = ctx.status(res)
 
 	m := Map{
 		"code": code,
@@ -1518,11 +1516,7 @@ func (ctx *Http) Answer(res *Res, args ...Map) {
 		text = ctx.String(res.Text, res.Args...)
 	}
 
-	if res.OK() {
-		ctx.Code = http.StatusOK
-	} else {
-		ctx.Code = http.StatusInternalServerError
-	}
+	ctx.Code = ctx.status(res)
 
 	var data Map
 	if res.OK() {
@@ -1550,6 +1544,17 @@ func (ctx *Http) Answer(res *Res, args ...Map) {
 	ctx.Body = httpApiBody{code, text, data}
 }
 
+//结果对应的HTTP状态，没有定义的，成功200，失败500
+func (ctx *Http) status(res *Res) int {
+	if res.OK() {
+		if res == nil {
+			return http.StatusOK
+		}
+		return ark.Basic.Status(res.Text, http.StatusOK)
+	}
+	return ark.Basic.Status(res.Text, http.StatusInternalServerError)
+}
+
 //通用方法
 func (ctx *Http) UserAgent() string {
 	return ctx.Header("User-Agent")
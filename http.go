@@ -172,6 +172,9 @@ type (
 
 		connect HttpConnect
 		url     *httpUrl
+
+		//访问日志
+		access *httpAccess
 	}
 
 	Filter struct {
@@ -351,12 +354,39 @@ func (module *httpModule) connecting(config HttpConfig) (HttpConnect, error) {
 	}
 	panic("[HTTP]不支持的驱动" + config.Driver)
 }
+
+//内置状态使用对应的HTTP状态，会改变客户端看到的状态码，要配置开启
+//[http.setting]
+//status = true
+//开启后 found 404，retry 503，invalid 400，denied 403，已经用ResultStatus设置过的不变
+func (module *httpModule) statusing() {
+	if vv, ok := ark.Config.Http.Setting["status"].(bool); !ok || vv == false {
+		return
+	}
+	defaults := []struct {
+		res    *Res
+		status int
+	}{
+		{Found, http.StatusNotFound},
+		{Retry, http.StatusServiceUnavailable},
+		{Invalid, http.StatusBadRequest},
+	}
+	for _, vv := range defaults {
+		if vv.res != nil && ark.Basic.Status(vv.res.Text) == 0 {
+			ResultStatus(vv.res, vv.status)
+		}
+	}
+}
+
 func (module *httpModule) initing() {
 
 	module.initRouterActions()
 	module.initFilterActions()
 	module.initHandlerActions()
 
+	module.access = module.accessing()
+	module.statusing()
+
 	connect, err := module.connecting(ark.Config.Http)
 	if err != nil {
 		panic("[HTTP]连接失败：" + err.Error())
@@ -1209,6 +1239,28 @@ func (module *httpModule) serve(thread HttpThread) {
 
 	now := time.Now()
 
+	//访问日志放在defer中，处理中panic的也要记录，没写出状态的按500记
+	defer func() {
+		if err := recover(); err != nil {
+			if writer, ok := ctx.response.(*httpWriter); !ok || writer.status == 0 {
+				ctx.Code = http.StatusInternalServerError
+			}
+			module.accessLog(ctx, now)
+			panic(err)
+		}
+		module.accessLog(ctx, now)
+	}()
+
+	//跟踪id，可以从请求头中传入，响应时带回
+	traceHeader := "X-Request-Id"
+	if vv, ok := ark.Config.Http.Setting["trace"].(string); ok && vv != "" {
+		traceHeader = vv
+	}
+	if trace := ctx.Header(traceHeader); trace != "" && len(trace) <= 128 {
+		ctx.TraceId(trace)
+	}
+	ctx.Header(traceHeader, ctx.TraceId())
+
 	//请求id
 	ctx.Id = ctx.Cookie(ctx.siteConfig.Cookie)
 	if ctx.Id == "" {
@@ -1918,6 +1970,11 @@ func (module *httpModule) errorDefaultHandler(ctx *Http) {
 
 	ctx.Code = http.StatusInternalServerError
 
+	//底层错误只写日志，不给客户端
+	if cause := Cause(error); cause != nil {
+		ctx.Logger("http").Warning("[HTTP]请求错误", "name", ctx.Name, "result", error.Text, "cause", cause)
+	}
+
 	if ctx.Ajax {
 		ctx.Answer(error)
 	} else {
@@ -88,6 +88,8 @@ func Register(args ...Any) {
 		ark.Bus.Event(key, val, override)
 	case Queue:
 		ark.Bus.Queue(key, val, override)
+	case BusFilter:
+		ark.Bus.Filter(key, val, override)
 
 	case Table:
 		ark.Data.Table(key, val, override)
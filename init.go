@@ -1,6 +1,7 @@
 package ark
 
 import (
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -139,8 +140,16 @@ func config() *arkConfig {
 			config.Mutex[k] = v
 		}
 	}
+	//日志多输出，[logger.xxx]
+	var raw struct {
+		Logger Map `toml:"logger"`
+	}
+	if err := loading(cfgfile, &raw); err == nil {
+		config.Logger.Outputs = loggerOutputs(raw.Logger)
+	}
+
 	//日志默认配置
-	if config.Logger.Driver == "" {
+	if config.Logger.Driver == "" && len(config.Logger.Outputs) == 0 {
 		config.Logger.Driver = DEFAULT
 		config.Logger.Console = true
 	}
@@ -404,4 +413,9 @@ func build() {
 	Found = Result(-2, "found", "不存在")
 	Retry = Result(-3, "retry", "请稍后再试")
 	Invalid = Result(-4, "invalid", "无效数据或请求")
+
+	//默认和以前一样，成功200，失败500
+	//其它状态对应的HTTP状态在http初始化时按配置开启，见 httpModule.statusing
+	ResultStatus(OK, http.StatusOK)
+	ResultStatus(Fail, http.StatusInternalServerError)
 }
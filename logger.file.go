@@ -0,0 +1,491 @@
+package ark
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//文件日志驱动，按大小和时间切割，可以按级别分文件
+//[logger]
+//driver = "file"
+//format = "json"
+//[logger.setting]
+//path = "logs/ark.log"
+//maxsize = "100MB"
+//rotate = "daily"
+//keep = 30         #保留的文件个数，也可以写时长，比如 "7d"
+//maxage = "30d"
+//gzip = true
+//split = true
+
+type (
+	fileLoggerDriver struct{}
+
+	fileLoggerSetting struct {
+		Path    string
+		MaxSize int64
+		Rotate  string
+		Keep    int
+		MaxAge  time.Duration
+		Gzip    bool
+		Split   bool
+	}
+
+	fileLoggerConnect struct {
+		mutex   sync.Mutex
+		config  LoggerConfig
+		setting fileLoggerSetting
+
+		writers  map[string]*fileLoggerWriter
+		cleaning sync.WaitGroup
+		cleaner  sync.Mutex
+	}
+
+	fileLoggerWriter struct {
+		mutex   sync.Mutex
+		connect *fileLoggerConnect
+		path    string
+		file    *os.File
+		size    int64
+		period  string
+	}
+)
+
+func (driver *fileLoggerDriver) Connect(config LoggerConfig) (LoggerConnect, error) {
+	setting := fileLoggerSetting{
+		Path: "logs/ark.log", Keep: 0,
+	}
+
+	if vv, ok := config.Setting["path"].(string); ok && vv != "" {
+		setting.Path = vv
+	} else if vv, ok := config.Setting["file"].(string); ok && vv != "" {
+		setting.Path = vv
+	}
+	if vv, ok := config.Setting["maxsize"]; ok {
+		size, err := fileLoggerSize(vv)
+		if err != nil {
+			return nil, err
+		}
+		setting.MaxSize = size
+	}
+	if vv, ok := config.Setting["rotate"].(string); ok {
+		switch strings.ToLower(vv) {
+		case "", "none":
+		case "daily", "day":
+			setting.Rotate = "daily"
+		case "hourly", "hour":
+			setting.Rotate = "hourly"
+		default:
+			return nil, errors.New("无效的日志切割方式：" + vv)
+		}
+	}
+	if vv, ok := config.Setting["keep"]; ok {
+		keep, age, err := fileLoggerKeep(vv)
+		if err != nil {
+			return nil, err
+		}
+		setting.Keep = keep
+		if age > 0 {
+			setting.MaxAge = age
+		}
+	}
+	if vv, ok := config.Setting["maxage"]; ok {
+		age, err := fileLoggerDuration(vv)
+		if err != nil {
+			return nil, err
+		}
+		setting.MaxAge = age
+	}
+	if vv, ok := config.Setting["gzip"].(bool); ok {
+		setting.Gzip = vv
+	}
+	if vv, ok := config.Setting["split"].(bool); ok {
+		setting.Split = vv
+	}
+
+	return &fileLoggerConnect{
+		config: config, setting: setting,
+		writers: map[string]*fileLoggerWriter{},
+	}, nil
+}
+
+//大小，支持 1024、"512KB"、"100MB"、"1GB"
+func fileLoggerSize(value Any) (int64, error) {
+	switch vv := value.(type) {
+	case int:
+		return int64(vv), nil
+	case int64:
+		return vv, nil
+	case float64:
+		return int64(vv), nil
+	case string:
+		text := strings.ToUpper(strings.TrimSpace(vv))
+		unit := int64(1)
+		for _, suffix := range []struct {
+			name string
+			size int64
+		}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+			if strings.HasSuffix(text, suffix.name) {
+				text = strings.TrimSpace(strings.TrimSuffix(text, suffix.name))
+				unit = suffix.size
+				break
+			}
+		}
+		num, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, errors.New("无效的大小：" + vv)
+		}
+		return int64(num * float64(unit)), nil
+	}
+	return 0, fmt.Errorf("无效的大小：%v", value)
+}
+
+//保留，数字是保留的文件个数，带单位的是保留的时长，比如 30、"30"、"7d"、"72h"
+func fileLoggerKeep(value Any) (int, time.Duration, error) {
+	switch vv := value.(type) {
+	case int:
+		return vv, 0, nil
+	case int64:
+		return int(vv), 0, nil
+	case float64:
+		return int(vv), 0, nil
+	case string:
+		text := strings.TrimSpace(vv)
+		if count, err := strconv.Atoi(text); err == nil {
+			return count, 0, nil
+		}
+		age, err := fileLoggerDuration(text)
+		if err != nil {
+			return 0, 0, errors.New("无效的保留设置：" + vv)
+		}
+		return 0, age, nil
+	}
+	return 0, 0, fmt.Errorf("无效的保留设置：%v", value)
+}
+
+//时长，支持 time.ParseDuration 的格式，另外支持 "30d" 按天
+func fileLoggerDuration(value Any) (time.Duration, error) {
+	switch vv := value.(type) {
+	case int:
+		return time.Second * time.Duration(vv), nil
+	case int64:
+		return time.Second * time.Duration(vv), nil
+	case float64:
+		return time.Second * time.Duration(vv), nil
+	case string:
+		text := strings.TrimSpace(vv)
+		if strings.HasSuffix(text, "d") {
+			days, err := strconv.Atoi(strings.TrimSuffix(text, "d"))
+			if err != nil {
+				return 0, errors.New("无效的时长：" + vv)
+			}
+			return time.Hour * 24 * time.Duration(days), nil
+		}
+		return time.ParseDuration(text)
+	}
+	return 0, fmt.Errorf("无效的时长：%v", value)
+}
+
+//打开连接
+func (connect *fileLoggerConnect) Open() error {
+	dir := filepath.Dir(connect.setting.Path)
+	return os.MkdirAll(dir, 0755)
+}
+
+func (connect *fileLoggerConnect) Health() (LoggerHealth, error) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	return LoggerHealth{Workload: int64(len(connect.writers))}, nil
+}
+
+//关闭连接，等待压缩和清理完成
+func (connect *fileLoggerConnect) Close() error {
+	connect.mutex.Lock()
+	for _, writer := range connect.writers {
+		writer.close()
+	}
+	connect.writers = map[string]*fileLoggerWriter{}
+	connect.mutex.Unlock()
+
+	connect.cleaning.Wait()
+	return nil
+}
+
+//按级别分文件的，文件名为 ark.error.log 这样
+func (connect *fileLoggerConnect) writer(level LoggerLevel) *fileLoggerWriter {
+	key := ""
+	if connect.setting.Split {
+		key = level.String()
+	}
+
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	if writer, ok := connect.writers[key]; ok {
+		return writer
+	}
+
+	path := connect.setting.Path
+	if key != "" {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "." + key + ext
+	}
+
+	writer := &fileLoggerWriter{connect: connect, path: path}
+	connect.writers[key] = writer
+	return writer
+}
+
+// Record 写入日志记录
+func (connect *fileLoggerConnect) Record(record LoggerRecord) error {
+	line := ark.Logger.Format(connect.config.Format, record) + "\n"
+	return connect.writer(record.Level).write(record.Time, []byte(line))
+}
+
+func (connect *fileLoggerConnect) message(level LoggerLevel, msg string) {
+	connect.Record(LoggerRecord{Level: level, Time: time.Now(), Message: msg})
+}
+
+func (connect *fileLoggerConnect) Debug(msg string) {
+	connect.message(LevelDebug, msg)
+}
+func (connect *fileLoggerConnect) Debugf(format string, args ...Any) {
+	connect.message(LevelDebug, fmt.Sprintf(format, args...))
+}
+func (connect *fileLoggerConnect) Trace(msg string) {
+	connect.message(LevelTrace, msg)
+}
+func (connect *fileLoggerConnect) Tracef(format string, args ...Any) {
+	connect.message(LevelTrace, fmt.Sprintf(format, args...))
+}
+func (connect *fileLoggerConnect) Info(msg string) {
+	connect.message(LevelInfo, msg)
+}
+func (connect *fileLoggerConnect) Infof(format string, args ...Any) {
+	connect.message(LevelInfo, fmt.Sprintf(format, args...))
+}
+func (connect *fileLoggerConnect) Warning(msg string) {
+	connect.message(LevelWarning, msg)
+}
+func (connect *fileLoggerConnect) Warningf(format string, args ...Any) {
+	connect.message(LevelWarning, fmt.Sprintf(format, args...))
+}
+func (connect *fileLoggerConnect) Error(msg string) {
+	connect.message(LevelError, msg)
+}
+func (connect *fileLoggerConnect) Errorf(format string, args ...Any) {
+	connect.message(LevelError, fmt.Sprintf(format, args...))
+}
+
+//------------ writer ----------------
+
+//按时间切割的周期
+func (writer *fileLoggerWriter) periodOf(t time.Time) string {
+	switch writer.connect.setting.Rotate {
+	case "daily":
+		return t.Format("2006-01-02")
+	case "hourly":
+		return t.Format("2006-01-02T15")
+	}
+	return ""
+}
+
+func (writer *fileLoggerWriter) open(now time.Time) error {
+	file, err := os.OpenFile(writer.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	writer.file = file
+	writer.size = stat.Size()
+	//已经存在的文件，按修改时间算周期，重启后跨了周期也会切割
+	if writer.size > 0 {
+		writer.period = writer.periodOf(stat.ModTime())
+	} else {
+		writer.period = writer.periodOf(now)
+	}
+	return nil
+}
+
+func (writer *fileLoggerWriter) close() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file != nil {
+		writer.file.Close()
+		writer.file = nil
+	}
+}
+
+func (writer *fileLoggerWriter) write(now time.Time, data []byte) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.file == nil {
+		if err := writer.open(now); err != nil {
+			return err
+		}
+	}
+
+	setting := writer.connect.setting
+	if writer.size > 0 {
+		if (setting.MaxSize > 0 && writer.size+int64(len(data)) > setting.MaxSize) || writer.period != writer.periodOf(now) {
+			if err := writer.rotate(now); err != nil {
+				return err
+			}
+		}
+	}
+
+	n, err := writer.file.Write(data)
+	writer.size += int64(n)
+	return err
+}
+
+//切割，当前文件改名为 ark.2006-01-02T15-04-05.log
+func (writer *fileLoggerWriter) rotate(now time.Time) error {
+	writer.file.Close()
+	writer.file = nil
+
+	ext := filepath.Ext(writer.path)
+	base := strings.TrimSuffix(writer.path, ext)
+	stamp := now.Format("2006-01-02T15-04-05")
+	target := fmt.Sprintf("%s.%s%s", base, stamp, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			if _, err := os.Stat(target + ".gz"); os.IsNotExist(err) {
+				break
+			}
+		}
+		target = fmt.Sprintf("%s.%s.%d%s", base, stamp, i, ext)
+	}
+
+	if err := os.Rename(writer.path, target); err != nil {
+		return err
+	}
+	if err := writer.open(now); err != nil {
+		return err
+	}
+
+	//压缩和清理放到后台，不阻塞写日志
+	writer.connect.cleaning.Add(1)
+	go writer.cleanup(target)
+
+	return nil
+}
+
+func (writer *fileLoggerWriter) cleanup(rotated string) {
+	defer writer.connect.cleaning.Done()
+
+	//多个文件同时切割时，压缩和清理要排队，否则可能删掉正在压缩的文件
+	writer.connect.cleaner.Lock()
+	defer writer.connect.cleaner.Unlock()
+
+	//排队期间可能已经被其它的清理删除了
+	setting := writer.connect.setting
+	//压缩失败的保留原文件，照样参与清理
+	if _, err := os.Stat(rotated); setting.Gzip && err == nil {
+		fileLoggerGzip(rotated)
+	}
+
+	if setting.Keep <= 0 && setting.MaxAge <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(writer.path)
+	base := strings.TrimSuffix(writer.path, ext)
+	files, err := filepath.Glob(base + ".*")
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path  string
+		time  time.Time
+		index int
+	}
+	backups := []backup{}
+	for _, file := range files {
+		name := strings.TrimSuffix(file, ".gz")
+		if file == writer.path || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		//分级别的文件 ark.error.log 也匹配 ark.*，排除掉
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), ext)
+		if len(stamp) < 19 {
+			continue
+		}
+		rotated, err := time.ParseInLocation("2006-01-02T15-04-05", stamp[:19], time.Local)
+		if err != nil {
+			continue
+		}
+		index := 0
+		if len(stamp) > 20 {
+			if index, err = strconv.Atoi(stamp[20:]); err != nil {
+				continue
+			}
+		}
+		backups = append(backups, backup{file, rotated, index})
+	}
+
+	//按切割时间排序，同一秒内切割的按序号
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].time.Equal(backups[j].time) {
+			return backups[i].index > backups[j].index
+		}
+		return backups[i].time.After(backups[j].time)
+	})
+
+	now := time.Now()
+	for i, item := range backups {
+		if (setting.Keep > 0 && i >= setting.Keep) || (setting.MaxAge > 0 && now.Sub(item.time) > setting.MaxAge) {
+			os.Remove(item.path)
+		}
+	}
+}
+
+func fileLoggerGzip(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	zip := gzip.NewWriter(dst)
+	if _, err := io.Copy(zip, src); err != nil {
+		zip.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zip.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
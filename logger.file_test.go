@@ -0,0 +1,246 @@
+package ark
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//文件日志的测试，每个测试一个临时目录，直接按给定时间写，不用真的等
+
+func TestFileLoggerKeep(t *testing.T) {
+	cases := []struct {
+		value Any
+		count int
+		age   time.Duration
+		fail  bool
+	}{
+		{30, 30, 0, false},
+		{int64(5), 5, 0, false},
+		{float64(7), 7, 0, false},
+		{"10", 10, 0, false},
+		{" 3 ", 3, 0, false},
+		{"7d", 0, 7 * 24 * time.Hour, false},
+		{"72h", 0, 72 * time.Hour, false},
+		{"90m", 0, 90 * time.Minute, false},
+		{"abc", 0, 0, true},
+		{"xd", 0, 0, true},
+		{true, 0, 0, true},
+	}
+
+	for _, tc := range cases {
+		count, age, err := fileLoggerKeep(tc.value)
+		if (err != nil) != tc.fail {
+			t.Fatalf("keep %v: err = %v", tc.value, err)
+		}
+		if count != tc.count || age != tc.age {
+			t.Fatalf("keep %v = %d, %s, want %d, %s", tc.value, count, age, tc.count, tc.age)
+		}
+	}
+}
+
+func TestFileLoggerSize(t *testing.T) {
+	cases := []struct {
+		value Any
+		size  int64
+		fail  bool
+	}{
+		{1024, 1024, false},
+		{"512KB", 512 << 10, false},
+		{"100mb", 100 << 20, false},
+		{"1.5G", 3 << 29, false},
+		{"20B", 20, false},
+		{"big", 0, true},
+	}
+
+	for _, tc := range cases {
+		size, err := fileLoggerSize(tc.value)
+		if (err != nil) != tc.fail {
+			t.Fatalf("size %v: err = %v", tc.value, err)
+		}
+		if size != tc.size {
+			t.Fatalf("size %v = %d, want %d", tc.value, size, tc.size)
+		}
+	}
+}
+
+func fileLoggerOpening(t *testing.T, setting Map) *fileLoggerConnect {
+	conn, err := (&fileLoggerDriver{}).Connect(LoggerConfig{Setting: setting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := conn.(*fileLoggerConnect)
+	if err := connect.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return connect
+}
+
+func fileLoggerWriting(t *testing.T, connect *fileLoggerConnect, at time.Time, text string) {
+	if err := connect.writer(LevelInfo).write(at, []byte(text+"\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//切割出来的文件，按名字排序就是按时间排序
+func fileLoggerBackups(t *testing.T, path string) []string {
+	files, err := filepath.Glob(strings.TrimSuffix(path, ".log") + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups := []string{}
+	for _, file := range files {
+		if file != path {
+			backups = append(backups, file)
+		}
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+func fileLoggerReading(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zip, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zip.Close()
+		reader = zip
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFileLoggerSizeRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ark.log")
+	connect := fileLoggerOpening(t, Map{"path": path, "maxsize": 10, "keep": "2"})
+
+	//每行6字节，超过10字节就切割，每写一行切一次
+	start := time.Date(2026, 10, 1, 8, 0, 0, 0, time.Local)
+	for i := 0; i < 6; i++ {
+		fileLoggerWriting(t, connect, start.Add(time.Duration(i)*time.Second), "line"+string(rune('0'+i)))
+	}
+	connect.Close()
+
+	if got := fileLoggerReading(t, path); got != "line5\n" {
+		t.Fatalf("current file = %q", got)
+	}
+	//只保留最新的两个
+	backups := fileLoggerBackups(t, path)
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want 2", backups)
+	}
+	if got := fileLoggerReading(t, backups[0]); got != "line3\n" {
+		t.Fatalf("older backup = %q", got)
+	}
+	if got := fileLoggerReading(t, backups[1]); got != "line4\n" {
+		t.Fatalf("newer backup = %q", got)
+	}
+}
+
+func TestFileLoggerSameSecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ark.log")
+	connect := fileLoggerOpening(t, Map{"path": path, "maxsize": 10, "keep": 2})
+
+	//同一秒内切割多次，用序号区分，清理时序号大的更新
+	at := time.Date(2026, 10, 1, 8, 0, 0, 0, time.Local)
+	for i := 0; i < 5; i++ {
+		fileLoggerWriting(t, connect, at, "line"+string(rune('0'+i)))
+	}
+	connect.Close()
+
+	backups := fileLoggerBackups(t, path)
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want 2", backups)
+	}
+	got := []string{fileLoggerReading(t, backups[0]), fileLoggerReading(t, backups[1])}
+	sort.Strings(got)
+	if got[0] != "line2\n" || got[1] != "line3\n" {
+		t.Fatalf("kept %q", got)
+	}
+}
+
+func TestFileLoggerMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ark.log")
+	connect := fileLoggerOpening(t, Map{"path": path, "maxsize": 10, "maxage": "1h"})
+
+	//三小时前切割的过期删除，刚切割的保留
+	old := time.Now().Add(-3 * time.Hour)
+	fileLoggerWriting(t, connect, old, "old00")
+	fileLoggerWriting(t, connect, old, "old01")
+	fileLoggerWriting(t, connect, time.Now(), "new00")
+	connect.Close()
+
+	backups := fileLoggerBackups(t, path)
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want 1", backups)
+	}
+	if got := fileLoggerReading(t, backups[0]); got != "old01\n" {
+		t.Fatalf("kept %q", got)
+	}
+}
+
+func TestFileLoggerDailyGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ark.log")
+	connect := fileLoggerOpening(t, Map{"path": path, "rotate": "daily", "gzip": true})
+
+	day := time.Date(2026, 10, 1, 23, 59, 0, 0, time.Local)
+	fileLoggerWriting(t, connect, day, "first")
+	fileLoggerWriting(t, connect, day.Add(30*time.Second), "second")
+	fileLoggerWriting(t, connect, day.Add(2*time.Minute), "third")
+	connect.Close()
+
+	//跨天切割一次，切出来的文件压缩后删掉原文件
+	backups := fileLoggerBackups(t, path)
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".log.gz") {
+		t.Fatalf("backups = %v, want one gzip file", backups)
+	}
+	if got := fileLoggerReading(t, backups[0]); got != "first\nsecond\n" {
+		t.Fatalf("gzip backup = %q", got)
+	}
+	if got := fileLoggerReading(t, path); got != "third\n" {
+		t.Fatalf("current file = %q", got)
+	}
+}
+
+func TestFileLoggerSplit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ark.log")
+	connect := fileLoggerOpening(t, Map{"path": path, "split": true, "maxsize": 10, "keep": 1})
+
+	at := time.Date(2026, 10, 1, 8, 0, 0, 0, time.Local)
+	connect.writer(LevelError).write(at, []byte("error\n"))
+	for i := 0; i < 3; i++ {
+		connect.writer(LevelInfo).write(at.Add(time.Duration(i)*time.Second), []byte("info"+string(rune('0'+i))+"\n"))
+	}
+	connect.Close()
+
+	//按级别分的文件互不影响，清理info的时候不会删掉error的文件
+	errorfile := filepath.Join(filepath.Dir(path), "ark.error.log")
+	if got := fileLoggerReading(t, errorfile); got != "error\n" {
+		t.Fatalf("error file = %q", got)
+	}
+	infos := filepath.Join(filepath.Dir(path), "ark.info.log")
+	if got := fileLoggerReading(t, infos); got != "info2\n" {
+		t.Fatalf("info file = %q", got)
+	}
+	if backups := fileLoggerBackups(t, infos); len(backups) != 1 {
+		t.Fatalf("info backups = %v, want 1", backups)
+	}
+}
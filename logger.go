@@ -1,9 +1,13 @@
 package ark
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/arkgo/asset"
@@ -19,6 +23,18 @@ type (
 		Level   string `toml:"level"`
 		Format  string `toml:"format"`
 		Setting Map    `toml:"setting"`
+
+		//各模块的日志级别，比如 http = "warning"
+		Levels map[string]string `toml:"levels"`
+
+		//异步缓冲的条数，默认1024，小于0为同步写
+		Buffer int `toml:"buffer"`
+
+		//专用输出，只接收这些名称的日志，这些名称的日志也不再写到其它输出
+		Names []string `toml:"names"`
+
+		//多个输出，配置为 [logger.xxx]，见 loggerOutputs
+		Outputs map[string]LoggerConfig `toml:"-"`
 	}
 
 	// LoggerDriver 日志驱动
@@ -44,25 +60,174 @@ type (
 		Errorf(string, ...Any)
 	}
 
+	// LoggerRecorder 结构化日志连接，驱动可选实现
+	// 实现了此接口的连接，直接收到完整的日志记录，不再调用Debug、Info等方法
+	LoggerRecorder interface {
+		Record(LoggerRecord) error
+	}
+
 	// LoggerHealth 日志健康信息
 	LoggerHealth struct {
 		Workload int64
 	}
 
+	// LoggerLevel 日志级别
+	LoggerLevel int
+
+	// LoggerRecord 日志记录
+	LoggerRecord struct {
+		Level   LoggerLevel
+		Time    time.Time
+		Name    string
+		Message string
+		Fields  Map
+	}
+
+	// Logging 带名称和字段的日志
+	Logging struct {
+		module *loggerModule
+		name   string
+		fields Map
+	}
+
 	loggerModule struct {
 		mutex   sync.Mutex
 		drivers map[string]LoggerDriver
 
+		//每条日志都要读，单独用读写锁
+		outputMutex sync.RWMutex
+		outputs     []*loggerOutput
+	}
+
+	//日志输出，每个输出有自己的驱动、级别和格式
+	loggerOutput struct {
+		name    string
+		config  LoggerConfig
 		connect LoggerConnect
+
+		level  LoggerLevel
+		levels map[string]LoggerLevel
+
+		//专用输出的名称，以及被其它输出专用的名称
+		names  map[string]bool
+		claims map[string]bool
+
+		//关闭以后不再写入，发送和关闭缓冲都要持有锁，避免往关闭的缓冲发送
+		mutex   sync.RWMutex
+		closed  bool
+		queue   chan LoggerRecord
+		done    chan bool
+		dropped int64
+	}
+)
+
+const (
+	LevelDebug LoggerLevel = iota
+	LevelTrace
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+const (
+	LoggerText   = "text"
+	LoggerJson   = "json"
+	LoggerLogfmt = "logfmt"
+	LoggerRaw    = "raw"
+)
+
+var (
+	loggerLevels = map[LoggerLevel]string{
+		LevelDebug: "debug", LevelTrace: "trace", LevelInfo: "info",
+		LevelWarning: "warning", LevelError: "error",
 	}
 )
 
+func (level LoggerLevel) String() string {
+	if name, ok := loggerLevels[level]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+//解析级别名称，不认识的返回false
+func loggerLevel(name string) (LoggerLevel, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug", "all":
+		return LevelDebug, true
+	case "trace":
+		return LevelTrace, true
+	case "info":
+		return LevelInfo, true
+	case "warning", "warn":
+		return LevelWarning, true
+	case "error":
+		return LevelError, true
+	}
+	return LevelDebug, false
+}
+
 func newLogger() *loggerModule {
 	return &loggerModule{
 		drivers: map[string]LoggerDriver{},
 	}
 }
 
+//从 [logger] 的原始配置中取出多个输出
+//[logger.xxx] 子表都是输出，levels 和 setting 除外
+func loggerOutputs(raw Map) map[string]LoggerConfig {
+	outputs := map[string]LoggerConfig{}
+	for name, value := range raw {
+		if name == "levels" || name == "setting" {
+			continue
+		}
+		vals, ok := value.(Map)
+		if !ok {
+			continue
+		}
+
+		config := LoggerConfig{}
+		if vv, ok := vals["driver"].(string); ok {
+			config.Driver = vv
+		}
+		if vv, ok := vals["flag"].(string); ok {
+			config.Flag = vv
+		}
+		if vv, ok := vals["console"].(bool); ok {
+			config.Console = vv
+		}
+		if vv, ok := vals["level"].(string); ok {
+			config.Level = vv
+		}
+		if vv, ok := vals["format"].(string); ok {
+			config.Format = vv
+		}
+		if vv, ok := vals["buffer"].(int64); ok {
+			config.Buffer = int(vv)
+		}
+		if vvs, ok := vals["names"].([]Any); ok {
+			for _, vv := range vvs {
+				if name, ok := vv.(string); ok {
+					config.Names = append(config.Names, name)
+				}
+			}
+		}
+		if vv, ok := vals["setting"].(Map); ok {
+			config.Setting = vv
+		}
+		if vv, ok := vals["levels"].(Map); ok {
+			config.Levels = map[string]string{}
+			for k, v := range vv {
+				if level, ok := v.(string); ok {
+					config.Levels[k] = level
+				}
+			}
+		}
+		outputs[name] = config
+	}
+	return outputs
+}
+
 //注册日志驱动
 func (module *loggerModule) Driver(name string, driver LoggerDriver, overrides ...bool) {
 	module.mutex.Lock()
@@ -95,7 +260,105 @@ func (module *loggerModule) connecting(config LoggerConfig) (LoggerConnect, erro
 
 //初始化
 func (module *loggerModule) initing() {
-	connect, err := module.connecting(ark.Config.Logger)
+	config := ark.Config.Logger
+
+	//[logger] 本身配置了驱动的，也是一个输出
+	configs := map[string]LoggerConfig{}
+	for name, cfg := range config.Outputs {
+		configs[name] = cfg
+	}
+	if _, ok := configs[DEFAULT]; !ok && (config.Driver != "" || len(configs) == 0) {
+		configs[DEFAULT] = config
+	}
+
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	outputs := []*loggerOutput{}
+	for _, name := range names {
+		cfg := configs[name]
+
+		//输出没有配置的，继承 [logger] 的配置
+		if cfg.Driver == "" {
+			cfg.Driver = DEFAULT
+		}
+		if cfg.Level == "" {
+			cfg.Level = config.Level
+		}
+		if cfg.Format == "" {
+			cfg.Format = config.Format
+		}
+		if cfg.Buffer == 0 {
+			cfg.Buffer = config.Buffer
+		}
+		levels := map[string]string{}
+		for k, v := range config.Levels {
+			levels[k] = v
+		}
+		for k, v := range cfg.Levels {
+			levels[k] = v
+		}
+		cfg.Levels = levels
+
+		outputs = append(outputs, module.outputing(name, cfg))
+	}
+
+	//专用的名称，其它输出不再接收
+	claims := map[string]bool{}
+	for _, output := range outputs {
+		for name := range output.names {
+			claims[name] = true
+		}
+	}
+	for _, output := range outputs {
+		if len(output.names) == 0 {
+			output.claims = claims
+		}
+	}
+
+	module.outputMutex.Lock()
+	module.outputs = outputs
+	module.outputMutex.Unlock()
+}
+
+//当前的输出
+func (module *loggerModule) loaded() []*loggerOutput {
+	module.outputMutex.RLock()
+	defer module.outputMutex.RUnlock()
+	return module.outputs
+}
+
+//创建并打开一个输出
+func (module *loggerModule) outputing(name string, config LoggerConfig) *loggerOutput {
+	output := &loggerOutput{
+		name: name, config: config,
+		level: LevelDebug, levels: map[string]LoggerLevel{},
+		names: map[string]bool{}, claims: map[string]bool{},
+	}
+	for _, key := range config.Names {
+		output.names[key] = true
+	}
+
+	//日志级别
+	if config.Level != "" {
+		level, ok := loggerLevel(config.Level)
+		if !ok {
+			panic("[日志]无效的日志级别：" + config.Level)
+		}
+		output.level = level
+	}
+	for key, value := range config.Levels {
+		level, ok := loggerLevel(value)
+		if !ok {
+			panic("[日志]无效的日志级别：" + key + " = " + value)
+		}
+		output.levels[key] = level
+	}
+
+	connect, err := module.connecting(config)
 	if err != nil {
 		panic("[日志]连接失败：" + err.Error())
 	}
@@ -107,13 +370,42 @@ func (module *loggerModule) initing() {
 	}
 
 	//保存连接
-	module.connect = connect
+	output.connect = connect
+
+	//异步写，慢的输出不影响其它输出和业务
+	if config.Buffer >= 0 {
+		size := config.Buffer
+		if size == 0 {
+			size = 1024
+		}
+		output.queue = make(chan LoggerRecord, size)
+		output.done = make(chan bool)
+		go output.running()
+	}
+
+	return output
 }
 
 //退出
 func (module *loggerModule) exiting() {
-	if module.connect != nil {
-		module.connect.Close()
+	module.outputMutex.Lock()
+	outputs := module.outputs
+	module.outputs = nil
+	module.outputMutex.Unlock()
+
+	//已经拿到输出的调用方可能还在写，先标记关闭，再关闭缓冲
+	for _, output := range outputs {
+		output.mutex.Lock()
+		output.closed = true
+		if output.queue != nil {
+			close(output.queue)
+		}
+		output.mutex.Unlock()
+
+		if output.queue != nil {
+			<-output.done
+		}
+		output.connect.Close()
 	}
 }
 
@@ -161,8 +453,9 @@ func (module *loggerModule) tostring(args ...Any) string {
 //output是为了直接输出到控制台，不管是否启用控制台
 
 func (module *loggerModule) output(args ...Any) {
-	if ark.Config.Logger.Console && module.connect != nil {
-		module.Info(args...)
+	if ark.Config.Logger.Console && len(module.loaded()) > 0 {
+		//不受日志级别限制
+		module.record(LoggerRecord{Level: LevelInfo, Time: time.Now(), Message: module.message(args)})
 	} else {
 		ts := time.Now().Format("2006-01-02 15:04:05")
 		format, args := module.formating(args)
@@ -178,79 +471,366 @@ func (module *loggerModule) output(args ...Any) {
 	}
 }
 
-//调试
-func (module *loggerModule) Debug(args ...Any) {
-	if module.connect != nil {
-		format, args := module.formating(args)
-		if format != "" {
-			module.connect.Debugf(format, args...)
-		} else {
-			s := module.tostring(args...)
-			module.connect.Debug(s)
+//兼容旧的写法，参数拼成消息，没有字段
+func (module *loggerModule) message(args []Any) string {
+	format, args := module.formating(args)
+	if format != "" {
+		return fmt.Sprintf(format, args...)
+	}
+	return module.tostring(args...)
+}
+
+//把 "key", val, ... 这样的参数转成字段
+//Map直接合并，落单的值使用!BADKEY作为键
+func (module *loggerModule) fielding(fields Map, keyvals []Any) Map {
+	out := Map{}
+	for k, v := range fields {
+		out[k] = v
+	}
+	for i := 0; i < len(keyvals); i++ {
+		switch key := keyvals[i].(type) {
+		case Map:
+			for k, v := range key {
+				out[k] = v
+			}
+		case string:
+			if i+1 < len(keyvals) {
+				out[key] = keyvals[i+1]
+				i++
+			} else {
+				out["!BADKEY"] = key
+			}
+		default:
+			out["!BADKEY"] = key
 		}
-	} else {
-		module.output(args...)
 	}
+	return out
 }
 
-//信息
-func (module *loggerModule) Trace(args ...Any) {
-	if module.connect != nil {
-		format, args := module.formating(args)
-		if format != "" {
-			module.connect.Tracef(format, args...)
-		} else {
-			s := module.tostring(args...)
-			module.connect.Trace(s)
+//写日志记录，分发到所有输出
+func (module *loggerModule) record(record LoggerRecord) {
+	outputs := module.loaded()
+	if len(outputs) == 0 {
+		fmt.Println(module.Format(ark.Config.Logger.Format, record))
+		return
+	}
+
+	for _, output := range outputs {
+		if output.enabled(record.Name, record.Level) {
+			output.record(record)
 		}
-	} else {
-		module.output(args...)
 	}
 }
 
-//信息
-func (module *loggerModule) Info(args ...Any) {
-	if module.connect != nil {
-		format, args := module.formating(args)
-		if format != "" {
-			module.connect.Infof(format, args...)
-		} else {
-			s := module.tostring(args...)
-			module.connect.Info(s)
+// Enabled 判断某个级别的日志是否输出，任一输出会输出即可
+// 有名称的日志优先使用 [logger.levels] 中的级别，其次是全局级别
+func (module *loggerModule) Enabled(name string, level LoggerLevel) bool {
+	outputs := module.loaded()
+	if len(outputs) == 0 {
+		return true
+	}
+	for _, output := range outputs {
+		if output.enabled(name, level) {
+			return true
 		}
-	} else {
-		module.output(args...)
 	}
+	return false
 }
 
-//警告
-func (module *loggerModule) Warning(args ...Any) {
-	if module.connect != nil {
-		format, args := module.formating(args)
-		if format != "" {
-			module.connect.Warningf(format, args...)
+//支持 http.access 这样的子名称，逐级向上查找
+func loggerEnabled(global LoggerLevel, levels map[string]LoggerLevel, name string, level LoggerLevel) bool {
+	for key := name; key != ""; {
+		if min, ok := levels[key]; ok {
+			return level >= min
+		}
+		if pos := strings.LastIndex(key, "."); pos > 0 {
+			key = key[:pos]
 		} else {
-			s := module.tostring(args...)
-			module.connect.Warning(s)
+			key = ""
 		}
-	} else {
-		module.output(args...)
 	}
+	return level >= global
 }
 
-//错误
-func (module *loggerModule) Error(args ...Any) {
-	if module.connect != nil {
-		format, args := module.formating(args)
-		if format != "" {
-			module.connect.Errorf(format, args...)
+func (module *loggerModule) logging(name string, level LoggerLevel, message string, fields Map) {
+	if !module.Enabled(name, level) {
+		return
+	}
+	module.record(LoggerRecord{
+		Level: level, Time: time.Now(), Name: name, Message: message, Fields: fields,
+	})
+}
+
+// Health 各输出的健康信息，Workload为缓冲中待写的条数
+func (module *loggerModule) Health() map[string]LoggerHealth {
+	healths := map[string]LoggerHealth{}
+	for _, output := range module.loaded() {
+		health, err := output.connect.Health()
+		if err != nil {
+			continue
+		}
+		if output.queue != nil {
+			health.Workload += int64(len(output.queue))
+		}
+		healths[output.name] = health
+	}
+	return healths
+}
+
+//------------ output ----------------
+
+func (output *loggerOutput) enabled(name string, level LoggerLevel) bool {
+	if len(output.names) > 0 {
+		if !loggerNamed(output.names, name) {
+			return false
+		}
+	} else if loggerNamed(output.claims, name) {
+		return false
+	}
+	return loggerEnabled(output.level, output.levels, name, level)
+}
+
+//名称是否在列表中，http.access 也匹配 http
+func loggerNamed(names map[string]bool, name string) bool {
+	for key := name; key != ""; {
+		if names[key] {
+			return true
+		}
+		if pos := strings.LastIndex(key, "."); pos > 0 {
+			key = key[:pos]
 		} else {
-			s := module.tostring(args...)
-			module.connect.Error(s)
+			key = ""
 		}
-	} else {
-		module.output(args...)
 	}
+	return false
+}
+
+//缓冲满了就丢弃并计数，不阻塞调用方
+func (output *loggerOutput) record(record LoggerRecord) {
+	output.mutex.RLock()
+	defer output.mutex.RUnlock()
+
+	if output.closed {
+		return
+	}
+	if output.queue == nil {
+		output.write(record)
+		return
+	}
+	select {
+	case output.queue <- record:
+	default:
+		atomic.AddInt64(&output.dropped, 1)
+	}
+}
+
+func (output *loggerOutput) running() {
+	for record := range output.queue {
+		output.write(record)
+		if dropped := atomic.SwapInt64(&output.dropped, 0); dropped > 0 {
+			output.write(LoggerRecord{
+				Level: LevelWarning, Time: time.Now(), Name: "logger",
+				Message: "[日志]缓冲已满，丢弃日志", Fields: Map{"output": output.name, "dropped": dropped},
+			})
+		}
+	}
+	output.done <- true
+}
+
+func (output *loggerOutput) write(record LoggerRecord) {
+	if recorder, ok := output.connect.(LoggerRecorder); ok {
+		recorder.Record(record)
+		return
+	}
+
+	//旧的连接只收字符串，时间和级别由驱动自己加
+	text := ""
+	switch strings.ToLower(output.config.Format) {
+	case LoggerJson, LoggerLogfmt, LoggerRaw:
+		text = ark.Logger.Format(output.config.Format, record)
+	default:
+		text = record.Message
+		if record.Name != "" {
+			text += " logger=" + record.Name
+		}
+		if len(record.Fields) > 0 {
+			text += " " + ark.Logger.logfmt(record.Fields)
+		}
+	}
+
+	switch record.Level {
+	case LevelDebug:
+		output.connect.Debug(text)
+	case LevelTrace:
+		output.connect.Trace(text)
+	case LevelInfo:
+		output.connect.Info(text)
+	case LevelWarning:
+		output.connect.Warning(text)
+	default:
+		output.connect.Error(text)
+	}
+}
+
+//字段按键排序，输出才稳定
+func (module *loggerModule) sorting(fields Map) []string {
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (module *loggerModule) logfmt(fields Map) string {
+	pairs := []string{}
+	for _, k := range module.sorting(fields) {
+		v := fmt.Sprintf("%v", fields[k])
+		if v == "" || strings.ContainsAny(v, " =\"\t\r\n") {
+			v = strconv.Quote(v)
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Format 按格式输出日志记录，text、json、logfmt、raw
+// 给驱动使用，实现了LoggerRecorder的驱动也可以直接用
+func (module *loggerModule) Format(format string, record LoggerRecord) string {
+	ts := record.Time.Format("2006-01-02 15:04:05.000")
+
+	switch strings.ToLower(format) {
+	case LoggerJson:
+		values := Map{}
+		for k, v := range record.Fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			values[k] = v
+		}
+		//保留键不被字段覆盖
+		values["time"] = record.Time.Format(time.RFC3339Nano)
+		values["level"] = record.Level.String()
+		values["msg"] = record.Message
+		if record.Name != "" {
+			values["logger"] = record.Name
+		}
+		for _, k := range []string{"time", "level", "logger", "msg"} {
+			if v, ok := record.Fields[k]; ok {
+				values["fields."+k] = v
+			}
+		}
+		bytes, err := json.Marshal(values)
+		if err != nil {
+			return fmt.Sprintf(`{"time":%q,"level":%q,"msg":%q,"error":%q}`, values["time"], values["level"], record.Message, err.Error())
+		}
+		return string(bytes)
+
+	case LoggerRaw:
+		//原样输出消息，比如访问日志
+		line := record.Message
+		if len(record.Fields) > 0 {
+			line += " " + module.logfmt(record.Fields)
+		}
+		return line
+
+	case LoggerLogfmt:
+		//time level logger msg 固定在前，字段按键排序在后
+		head := fmt.Sprintf("time=%s level=%s", record.Time.Format(time.RFC3339Nano), record.Level.String())
+		if record.Name != "" {
+			head += " logger=" + record.Name
+		}
+		head += " msg=" + strconv.Quote(record.Message)
+		if len(record.Fields) > 0 {
+			head += " " + module.logfmt(record.Fields)
+		}
+		return head
+
+	default:
+		line := fmt.Sprintf("%s [%s] %s", ts, strings.ToUpper(record.Level.String()), record.Message)
+		if record.Name != "" {
+			line += " logger=" + record.Name
+		}
+		if len(record.Fields) > 0 {
+			line += " " + module.logfmt(record.Fields)
+		}
+		return line
+	}
+}
+
+// With 生成带字段的日志
+func (module *loggerModule) With(fields Map) *Logging {
+	return &Logging{module: module, fields: module.fielding(fields, nil)}
+}
+
+// Logger 生成有名称的日志，级别在 [logger.levels] 中单独配置
+func (module *loggerModule) Logger(name string) *Logging {
+	return &Logging{module: module, name: name, fields: Map{}}
+}
+
+//调试
+func (module *loggerModule) Debug(args ...Any) {
+	module.logging("", LevelDebug, module.message(args), nil)
+}
+
+//信息
+func (module *loggerModule) Trace(args ...Any) {
+	module.logging("", LevelTrace, module.message(args), nil)
+}
+
+//信息
+func (module *loggerModule) Info(args ...Any) {
+	module.logging("", LevelInfo, module.message(args), nil)
+}
+
+//警告
+func (module *loggerModule) Warning(args ...Any) {
+	module.logging("", LevelWarning, module.message(args), nil)
+}
+
+//错误
+func (module *loggerModule) Error(args ...Any) {
+	module.logging("", LevelError, module.message(args), nil)
+}
+
+// With 在现有字段上追加字段
+func (logging *Logging) With(fields Map) *Logging {
+	return &Logging{module: logging.module, name: logging.name, fields: logging.module.fielding(logging.fields, []Any{fields})}
+}
+
+// Name 日志名称
+func (logging *Logging) Name() string {
+	return logging.name
+}
+
+// Enabled 判断某个级别是否会输出，可以避免准备昂贵的字段
+func (logging *Logging) Enabled(level LoggerLevel) bool {
+	return logging.module.Enabled(logging.name, level)
+}
+
+// Debug 调试，参数为 "key", val, ... 形式的字段
+func (logging *Logging) Debug(msg string, keyvals ...Any) {
+	logging.module.logging(logging.name, LevelDebug, msg, logging.module.fielding(logging.fields, keyvals))
+}
+
+// Trace 跟踪
+func (logging *Logging) Trace(msg string, keyvals ...Any) {
+	logging.module.logging(logging.name, LevelTrace, msg, logging.module.fielding(logging.fields, keyvals))
+}
+
+// Info 信息
+func (logging *Logging) Info(msg string, keyvals ...Any) {
+	logging.module.logging(logging.name, LevelInfo, msg, logging.module.fielding(logging.fields, keyvals))
+}
+
+// Warning 警告
+func (logging *Logging) Warning(msg string, keyvals ...Any) {
+	logging.module.logging(logging.name, LevelWarning, msg, logging.module.fielding(logging.fields, keyvals))
+}
+
+// Error 错误
+func (logging *Logging) Error(msg string, keyvals ...Any) {
+	logging.module.logging(logging.name, LevelError, msg, logging.module.fielding(logging.fields, keyvals))
 }
 
 //语法糖
@@ -270,3 +850,20 @@ func Warning(args ...Any) {
 func Error(args ...Any) {
 	ark.Logger.Error(args...)
 }
+
+//With 带字段的结构化日志
+//ark.With(Map{"user": id}).Info("登录", "ip", ip)
+func With(fields Map) *Logging {
+	return ark.Logger.With(fields)
+}
+
+//Logger 有名称的日志，比如 ark.Logger("http")
+//级别在 [logger.levels] 中配置
+func Logger(name string) *Logging {
+	return ark.Logger.Logger(name)
+}
+
+//LogFormat 按 text、json、logfmt、raw 格式化日志记录，给驱动用
+func LogFormat(format string, record LoggerRecord) string {
+	return ark.Logger.Format(format, record)
+}
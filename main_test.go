@@ -0,0 +1,21 @@
+package ark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//包初始化时按 os.Args[1] 加载配置文件，go test 传进来的是测试参数
+//初始化之前先换成测试用的配置，TestMain 里再换回来，测试参数才能正常解析
+//包级变量在 init 之前初始化，所以能赶在加载配置之前
+var testingArgs = func() []string {
+	args := os.Args
+	os.Args = append([]string{args[0], filepath.Join("testdata", "config.toml")}, args[1:]...)
+	return args
+}()
+
+func TestMain(m *testing.M) {
+	os.Args = testingArgs
+	os.Exit(m.Run())
+}
@@ -0,0 +1,306 @@
+package ark
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//文件互斥驱动，同一台机器上的多个进程共用，使用文件锁
+//每个key一个锁文件，文件中记录持有者和过期时间
+//[mutex.default]
+//driver = "file"
+//[mutex.default.setting]
+//path = "/var/run/ark/locks"
+
+const (
+	fileMutexExt   = ".lock"
+	fileMutexFence = "fence"
+)
+
+type (
+	fileMutexDriver struct{}
+
+	fileMutexConnect struct {
+		mutex  sync.Mutex
+		name   string
+		config MutexConfig
+		path   string
+
+		closing chan bool
+	}
+)
+
+func (driver *fileMutexDriver) Connect(name string, config MutexConfig) (MutexConnect, error) {
+	path := filepath.Join(os.TempDir(), "ark", "locks", name)
+	if vv, ok := config.Setting["path"].(string); ok && vv != "" {
+		path = vv
+	}
+	return &fileMutexConnect{name: name, config: config, path: path}, nil
+}
+
+//打开连接，定时清理过期的锁文件
+func (connect *fileMutexConnect) Open() error {
+	if err := os.MkdirAll(connect.path, 0755); err != nil {
+		return err
+	}
+
+	closing := make(chan bool)
+	connect.closing = closing
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closing:
+				return
+			case <-ticker.C:
+				connect.sweeping()
+			}
+		}
+	}()
+	return nil
+}
+
+func (connect *fileMutexConnect) Health() (MutexHealth, error) {
+	files, err := filepath.Glob(filepath.Join(connect.path, "*"+fileMutexExt))
+	if err != nil {
+		return MutexHealth{}, err
+	}
+	return MutexHealth{Workload: int64(len(files))}, nil
+}
+
+func (connect *fileMutexConnect) Close() error {
+	if connect.closing != nil {
+		close(connect.closing)
+		connect.closing = nil
+	}
+	return nil
+}
+
+//key转文件名，太长的用sha1
+func (connect *fileMutexConnect) file(key string) string {
+	name := url.QueryEscape(connect.config.Prefix + key)
+	if len(name) > 200 {
+		sum := sha1.Sum([]byte(name))
+		name = hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(connect.path, name+fileMutexExt)
+}
+
+//打开并锁住文件
+//加锁前文件可能已被其它进程删除，要确认锁住的还是路径上的文件
+func (connect *fileMutexConnect) opening(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := fileMutexFlock(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		opened, err1 := file.Stat()
+		current, err2 := os.Stat(path)
+		if err1 == nil && err2 == nil && os.SameFile(opened, current) {
+			return file, nil
+		}
+
+		fileMutexFunlock(file)
+		file.Close()
+		if err1 != nil {
+			return nil, err1
+		}
+	}
+}
+
+//递增的fencing序号，所有进程共用一个文件
+func (connect *fileMutexConnect) fencing() (int64, error) {
+	file, err := connect.opening(filepath.Join(connect.path, fileMutexFence))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		fileMutexFunlock(file)
+		file.Close()
+	}()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	fence := int64(0)
+	if text := strings.TrimSpace(string(data)); text != "" {
+		if fence, err = strconv.ParseInt(text, 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	fence++
+
+	if err := fileMutexWrite(file, []byte(strconv.FormatInt(fence, 10))); err != nil {
+		return 0, err
+	}
+	return fence, nil
+}
+
+//在锁文件上操作，和内存驱动使用同样的持有者逻辑
+func (connect *fileMutexConnect) holding(key string, fencing bool, call func(*mutexHolders, time.Time) error) (int64, error) {
+	//同一进程内先串行，文件锁是进程级别的
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	path := connect.file(key)
+	file, err := connect.opening(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		fileMutexFunlock(file)
+		file.Close()
+	}()
+
+	holders := &mutexHolders{}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > 0 {
+		//内容损坏的当做没有持有者
+		if err := json.Unmarshal(data, holders); err != nil {
+			holders = &mutexHolders{}
+		}
+	}
+
+	err = call(holders, time.Now())
+
+	//先拿fencing序号再写入持有者，拿不到序号的不能留下持有记录，否则没人能解锁
+	fence := int64(0)
+	if err == nil && fencing {
+		fence, err = connect.fencing()
+	}
+
+	//没有持有者了就删除文件，其它进程加锁后会发现文件已变更
+	if holders.empty() {
+		os.Remove(path)
+	} else if err == nil {
+		bytes, err := json.Marshal(holders)
+		if err != nil {
+			return 0, err
+		}
+		if err := fileMutexWrite(file, bytes); err != nil {
+			return 0, err
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return fence, nil
+}
+
+//清理过期的锁文件
+func (connect *fileMutexConnect) sweeping() {
+	files, err := filepath.Glob(filepath.Join(connect.path, "*"+fileMutexExt))
+	if err != nil {
+		return
+	}
+	for _, path := range files {
+		connect.mutex.Lock()
+		file, err := connect.opening(path)
+		if err == nil {
+			holders := &mutexHolders{}
+			data, _ := ioutil.ReadAll(file)
+			if json.Unmarshal(data, holders) == nil {
+				holders.clean(time.Now())
+			}
+			if holders.empty() {
+				os.Remove(path)
+			}
+			fileMutexFunlock(file)
+			file.Close()
+		}
+		connect.mutex.Unlock()
+	}
+}
+
+func fileMutexWrite(file *os.File, data []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (connect *fileMutexConnect) Lock(key, token string, expiry time.Duration) (int64, error) {
+	return connect.holding(key, true, func(holders *mutexHolders, now time.Time) error {
+		return holders.lock(token, now.Add(expiry), now)
+	})
+}
+
+func (connect *fileMutexConnect) Unlock(key, token string) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.unlock(token)
+	})
+	return err
+}
+
+func (connect *fileMutexConnect) Extend(key, token string, expiry time.Duration) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.extend(token, now.Add(expiry), now)
+	})
+	return err
+}
+
+func (connect *fileMutexConnect) RLock(key, token string, expiry time.Duration) (int64, error) {
+	return connect.holding(key, true, func(holders *mutexHolders, now time.Time) error {
+		return holders.rlock(token, now.Add(expiry), now)
+	})
+}
+
+func (connect *fileMutexConnect) RUnlock(key, token string) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.runlock(token)
+	})
+	return err
+}
+
+func (connect *fileMutexConnect) Wait(key, token string, expiry time.Duration) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.wait(token, now.Add(expiry), now)
+	})
+	return err
+}
+
+func (connect *fileMutexConnect) Unwait(key, token string) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.unwait(token)
+	})
+	return err
+}
+
+func (connect *fileMutexConnect) Acquire(name, token string, limit int, expiry time.Duration) (int64, error) {
+	return connect.holding(name, true, func(holders *mutexHolders, now time.Time) error {
+		return holders.acquire(token, limit, now.Add(expiry), now)
+	})
+}
+
+func (connect *fileMutexConnect) Release(name, token string) error {
+	_, err := connect.holding(name, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.release(token)
+	})
+	return err
+}
+
+var errFileMutexUnsupported = errors.New("当前系统不支持文件互斥驱动")
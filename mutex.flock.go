@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package ark
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileMutexFlock(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func fileMutexFunlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+//不等待，被占用时返回false
+func fileMutexTryFlock(file *os.File) (bool, error) {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		if err != syscall.EINTR {
+			return err == nil, err
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package ark
+
+import (
+	"os"
+)
+
+func fileMutexFlock(file *os.File) error {
+	return errFileMutexUnsupported
+}
+
+func fileMutexFunlock(file *os.File) error {
+	return errFileMutexUnsupported
+}
+
+func fileMutexTryFlock(file *os.File) (bool, error) {
+	return false, errFileMutexUnsupported
+}
@@ -1,12 +1,17 @@
 package ark
 
 import (
+	gocontext "context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	mrand "math/rand"
 	"sync"
 	"time"
 
 	. "github.com/arkgo/asset"
 	"github.com/arkgo/asset/hashring"
+	"github.com/arkgo/asset/util"
 )
 
 type (
@@ -31,8 +36,53 @@ type (
 		Health() (MutexHealth, error)
 		Close() error
 
-		Lock(key string, expiries ...time.Duration) error
-		Unlock(key string) error
+		//加锁成功返回递增的fencing序号，可以用来保护下游的写入
+		//锁已被持有时返回 ErrMutexLocked
+		Lock(key, token string, expiry time.Duration) (int64, error)
+		//token不匹配时返回 ErrMutexToken，不会解开别人的锁
+		Unlock(key, token string) error
+		Extend(key, token string, expiry time.Duration) error
+	}
+
+	// MutexShared 读写锁，驱动可选实现
+	// 读锁可以同时持有多个，和 Lock 的写锁互斥，续期使用 Extend
+	MutexShared interface {
+		RLock(key, token string, expiry time.Duration) (int64, error)
+		RUnlock(key, token string) error
+	}
+
+	// MutexSemaphore 信号量，驱动可选实现
+	// 同一个name最多同时持有limit个，续期使用 Extend
+	MutexSemaphore interface {
+		Acquire(name, token string, limit int, expiry time.Duration) (int64, error)
+		Release(name, token string) error
+	}
+
+	// MutexWaiter 写锁排队，驱动可选实现
+	// LockWait 等待期间登记，登记了的写锁在时，新的读锁会失败，不再等待时取消登记
+	MutexWaiter interface {
+		Wait(key, token string, expiry time.Duration) error
+		Unwait(key, token string) error
+	}
+
+	// MutexLock 锁的句柄
+	// Expiry 是加锁或调用 Extend 时的过期时间，看门狗续期不会改它，实际的过期时间用 Deadline
+	MutexLock struct {
+		Key    string
+		Token  string
+		Fence  int64
+		Expiry time.Time
+
+		conn   string
+		kind   string
+		expiry time.Duration
+		module *mutexModule
+
+		//看门狗和调用方会同时访问
+		mutex    sync.Mutex
+		deadline time.Time
+		lost     chan bool
+		dropped  bool
 	}
 
 	// MutexHealth 互斥健康信息
@@ -45,15 +95,33 @@ type (
 		drivers map[string]MutexDriver
 
 		connects map[string]MutexConnect
+		expiries map[string]time.Duration
 		weights  map[string]int
 		hashring *hashring.HashRing
 	}
 )
 
+const (
+	mutexExclusive = ""
+	mutexShared    = "shared"
+	mutexSemaphore = "semaphore"
+)
+
+//写锁等待登记的有效期，LockWait 每次重试都会续上
+//重试的间隔小于这个时间，等待的进程挂了的话，最多挡住读锁这么久
+const mutexWaiting = time.Second
+
+var (
+	ErrMutexLocked = errors.New("已被锁定")
+	ErrMutexToken  = errors.New("锁已失效或不属于当前持有者")
+	ErrMutexLost   = errors.New("锁续期失败，已丢失")
+)
+
 func newMutex() *mutexModule {
 	return &mutexModule{
 		drivers:  make(map[string]MutexDriver),
 		connects: make(map[string]MutexConnect),
+		expiries: make(map[string]time.Duration),
 	}
 }
 
@@ -110,6 +178,15 @@ func (module *mutexModule) initing() {
 
 		//保存连接
 		module.connects[name] = connect
+
+		//默认过期时间
+		if config.Expiry != "" {
+			td, err := util.ParseDuration(config.Expiry)
+			if err != nil {
+				panic("[互斥]无效的过期时间：" + config.Expiry)
+			}
+			module.expiries[name] = td
+		}
 	}
 
 	//hashring分片
@@ -124,47 +201,348 @@ func (module *mutexModule) exiting() {
 	}
 }
 
-func (module *mutexModule) Lock(key string, expiry time.Duration, cons ...string) error {
+//按key选择连接
+func (module *mutexModule) locate(key string, cons ...string) string {
 	con := DEFAULT
 	if len(cons) > 0 && cons[0] != "" {
 		con = cons[0]
 	} else if module.hashring != nil {
 		con = module.hashring.Locate(key)
 	}
+	return con
+}
 
-	expiries := make([]time.Duration, 0)
+//过期时间为0时，使用连接配置的默认过期时间
+func (module *mutexModule) expiry(con string, expiry time.Duration) time.Duration {
 	if expiry > 0 {
-		expiries = append(expiries, expiry)
+		return expiry
+	}
+	if td, ok := module.expiries[con]; ok {
+		return td
+	}
+	return time.Second * 2
+}
+
+//随机token，标识锁的持有者
+func mutexToken() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return ark.Codec.Unique()
 	}
+	return hex.EncodeToString(bytes)
+}
 
-	if connect, ok := module.connects[con]; ok {
-		return connect.Lock(key, expiries...)
+//加锁，写锁、读锁、信号量都走这里
+func (module *mutexModule) locking(kind, key string, limit int, expiry time.Duration, cons ...string) (*MutexLock, error) {
+	con := module.locate(key, cons...)
+	connect, ok := module.connects[con]
+	if !ok {
+		return nil, errors.New("无效互斥连接")
 	}
 
-	return errors.New("无效互斥连接")
+	expiry = module.expiry(con, expiry)
+	token := mutexToken()
+	now := time.Now()
+
+	var fence int64
+	var err error
+	switch kind {
+	case mutexShared:
+		shared, ok := connect.(MutexShared)
+		if !ok {
+			return nil, errors.New("互斥驱动不支持读写锁")
+		}
+		fence, err = shared.RLock(key, token, expiry)
+	case mutexSemaphore:
+		semaphore, ok := connect.(MutexSemaphore)
+		if !ok {
+			return nil, errors.New("互斥驱动不支持信号量")
+		}
+		if limit <= 0 {
+			limit = 1
+		}
+		fence, err = semaphore.Acquire(key, token, limit, expiry)
+	default:
+		fence, err = connect.Lock(key, token, expiry)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &MutexLock{
+		Key: key, Token: token, Fence: fence, Expiry: now.Add(expiry),
+		conn: con, kind: kind, expiry: expiry, module: module,
+		deadline: now.Add(expiry), lost: make(chan bool),
+	}, nil
 }
-func (module *mutexModule) Unlock(key string, cons ...string) error {
-	con := DEFAULT
-	if len(cons) > 0 && cons[0] != "" {
-		con = cons[0]
-	} else if module.hashring != nil {
-		con = module.hashring.Locate(key)
+
+// Lock 加锁，返回锁的句柄，用句柄解锁和续期
+func (module *mutexModule) Lock(key string, expiry time.Duration, cons ...string) (*MutexLock, error) {
+	return module.locking(mutexExclusive, key, 0, expiry, cons...)
+}
+
+// RLock 加读锁，多个读锁可以同时持有，有写锁时失败
+func (module *mutexModule) RLock(key string, expiry time.Duration, cons ...string) (*MutexLock, error) {
+	return module.locking(mutexShared, key, 0, expiry, cons...)
+}
+
+// Acquire 获取信号量，同一个name最多同时持有limit个
+func (module *mutexModule) Acquire(name string, limit int, expiry time.Duration, cons ...string) (*MutexLock, error) {
+	return module.locking(mutexSemaphore, name, limit, expiry, cons...)
+}
+
+// Unlock 解锁，只有token匹配才会解开
+// 读锁和信号量也可以用Unlock释放
+func (module *mutexModule) Unlock(lock *MutexLock) error {
+	if lock == nil {
+		return ErrMutexToken
+	}
+	connect, ok := module.connects[lock.conn]
+	if !ok {
+		return errors.New("无效互斥连接")
+	}
+
+	switch lock.kind {
+	case mutexShared:
+		if shared, ok := connect.(MutexShared); ok {
+			return shared.RUnlock(lock.Key, lock.Token)
+		}
+		return errors.New("互斥驱动不支持读写锁")
+	case mutexSemaphore:
+		if semaphore, ok := connect.(MutexSemaphore); ok {
+			return semaphore.Release(lock.Key, lock.Token)
+		}
+		return errors.New("互斥驱动不支持信号量")
+	}
+
+	return connect.Unlock(lock.Key, lock.Token)
+}
+
+// Extend 续期，只有token匹配且锁未过期才会成功
+func (module *mutexModule) Extend(lock *MutexLock, expiry time.Duration) error {
+	deadline, err := module.extending(lock, expiry)
+	if err != nil {
+		return err
+	}
+	lock.Expiry = deadline
+	return nil
+}
+
+//续期，只更新内部的过期时间，看门狗也走这里
+func (module *mutexModule) extending(lock *MutexLock, expiry time.Duration) (time.Time, error) {
+	if lock == nil {
+		return time.Time{}, ErrMutexToken
+	}
+	connect, ok := module.connects[lock.conn]
+	if !ok {
+		return time.Time{}, errors.New("无效互斥连接")
 	}
 
-	if connect, ok := module.connects[con]; ok {
-		return connect.Unlock(key)
+	expiry = module.expiry(lock.conn, expiry)
+	now := time.Now()
+	if err := connect.Extend(lock.Key, lock.Token, expiry); err != nil {
+		return time.Time{}, err
 	}
 
-	return errors.New("无效互斥连接")
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	lock.deadline = now.Add(expiry)
+	lock.expiry = expiry
+	return lock.deadline, nil
+}
+
+// LockWait 等待加锁，锁被持有时随机退避重试，直到超时或ctx取消
+// timeout为0时只受ctx控制，超时返回 ErrMutexLocked
+func (module *mutexModule) LockWait(ctx gocontext.Context, key string, expiry, timeout time.Duration, cons ...string) (*MutexLock, error) {
+	if ctx == nil {
+		ctx = gocontext.Background()
+	}
+	if timeout > 0 {
+		var cancel gocontext.CancelFunc
+		ctx, cancel = gocontext.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	//等待期间登记，挡住新的读锁，返回时取消登记
+	waiter, waiting := module.connects[module.locate(key, cons...)].(MutexWaiter)
+	waitToken := mutexToken()
+	waited := false
+	defer func() {
+		if waited {
+			waiter.Unwait(key, waitToken)
+		}
+	}()
+
+	backoff := time.Millisecond * 10
+	for {
+		lock, err := module.Lock(key, expiry, cons...)
+		if err == nil {
+			return lock, nil
+		}
+		if err != ErrMutexLocked {
+			return nil, err
+		}
+		if waiting && waiter.Wait(key, waitToken, mutexWaiting) == nil {
+			waited = true
+		}
+
+		//随机抖动，避免多个等待者同时重试
+		wait := backoff/2 + time.Duration(mrand.Int63n(int64(backoff)))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if ctx.Err() == gocontext.DeadlineExceeded {
+				return nil, ErrMutexLocked
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		if backoff < time.Millisecond*500 {
+			backoff *= 2
+		}
+	}
+}
+
+// WithLock 加锁后执行，执行完一定会解锁
+// 执行期间自动续期，执行时间超过过期时间也不会丢锁
+// 续期失败时锁可能已被别人拿到，call没有返回错误的话返回 ErrMutexLost
+func (module *mutexModule) WithLock(key string, expiry time.Duration, call func() error, cons ...string) error {
+	lock, err := module.Lock(key, expiry, cons...)
+	if err != nil {
+		return err
+	}
+
+	stop := lock.Watch()
+	defer func() {
+		stop()
+		lock.Unlock()
+	}()
+
+	if err := call(); err != nil {
+		return err
+	}
+	select {
+	case <-lock.Lost():
+		return ErrMutexLost
+	default:
+		return nil
+	}
+}
+
+//看门狗，每过1/3的过期时间续期一次，续期失败说明锁已丢失，关闭lost通知持有者
+func (module *mutexModule) watching(lock *MutexLock, expiry time.Duration) func() {
+	stop := make(chan bool)
+	done := make(chan bool)
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(expiry / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := module.extending(lock, expiry); err != nil {
+					ark.Logger.Logger("mutex").Warning("[互斥]续期失败", "key", lock.Key, "error", err)
+					lock.dropping()
+					return
+				}
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+//------------ lock ----------------
+
+// Unlock 解锁
+func (lock *MutexLock) Unlock() error {
+	return lock.module.Unlock(lock)
+}
+
+// Extend 续期
+func (lock *MutexLock) Extend(expiry time.Duration) error {
+	return lock.module.Extend(lock, expiry)
+}
+
+// Watch 启动看门狗自动续期，返回停止函数
+// 续期失败时 Lost 会被关闭，执行中的任务应该尽快停下
+func (lock *MutexLock) Watch() func() {
+	lock.mutex.Lock()
+	expiry := lock.expiry
+	lock.mutex.Unlock()
+	return lock.module.watching(lock, expiry)
+}
+
+// Lost 锁丢失时关闭的通道，只有看门狗会发现丢失
+func (lock *MutexLock) Lost() <-chan bool {
+	return lock.lost
+}
+
+// Deadline 当前的过期时间，包括看门狗的续期
+func (lock *MutexLock) Deadline() time.Time {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	return lock.deadline
+}
+
+func (lock *MutexLock) dropping() {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if !lock.dropped {
+		lock.dropped = true
+		close(lock.lost)
+	}
 }
 
 //语法糖
+
+//Locked 加锁并持有到过期，已被锁定返回true，常用于防重复执行
 func Locked(key string, expiry time.Duration, cons ...string) bool {
-	return ark.Mutex.Lock(key, expiry, cons...) != nil
+	_, err := ark.Mutex.Lock(key, expiry, cons...)
+	return err != nil
 }
-func Lock(key string, expiry time.Duration, cons ...string) error {
+func Lock(key string, expiry time.Duration, cons ...string) (*MutexLock, error) {
 	return ark.Mutex.Lock(key, expiry, cons...)
 }
-func Unlock(key string, cons ...string) error {
-	return ark.Mutex.Unlock(key, cons...)
+func Unlock(lock *MutexLock) error {
+	return ark.Mutex.Unlock(lock)
+}
+
+//RLock 加读锁
+func RLock(key string, expiry time.Duration, cons ...string) (*MutexLock, error) {
+	return ark.Mutex.RLock(key, expiry, cons...)
+}
+func RUnlock(lock *MutexLock) error {
+	return ark.Mutex.Unlock(lock)
+}
+
+//Acquire 获取信号量，比如全集群最多3个导出同时进行
+//lock, err := ark.Acquire("export", 3, time.Minute)
+func Acquire(name string, limit int, expiry time.Duration, cons ...string) (*MutexLock, error) {
+	return ark.Mutex.Acquire(name, limit, expiry, cons...)
+}
+func Release(lock *MutexLock) error {
+	return ark.Mutex.Unlock(lock)
+}
+
+//LockWait 等待加锁，ctx可以为nil
+func LockWait(ctx gocontext.Context, key string, expiry, timeout time.Duration, cons ...string) (*MutexLock, error) {
+	return ark.Mutex.LockWait(ctx, key, expiry, timeout, cons...)
+}
+
+//WithLock 加锁执行，自动续期，执行完解锁
+func WithLock(key string, expiry time.Duration, call func() error, cons ...string) error {
+	return ark.Mutex.WithLock(key, expiry, call, cons...)
 }
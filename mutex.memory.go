@@ -0,0 +1,301 @@
+package ark
+
+import (
+	"sync"
+	"time"
+)
+
+//内存互斥驱动，单进程内有效，默认驱动
+
+type (
+	memoryMutexDriver struct{}
+
+	memoryMutexConnect struct {
+		mutex  sync.Mutex
+		name   string
+		config MutexConfig
+
+		fence   int64
+		holders map[string]*mutexHolders
+
+		closing chan bool
+	}
+
+	//某个key的持有者，写锁、读锁、信号量，内存和文件驱动共用
+	//Waiters是登记了在等待的写锁，期间不再加新的读锁，避免读锁源源不断时写锁永远加不上
+	mutexHolders struct {
+		Token    string               `json:"token,omitempty"`
+		Deadline time.Time            `json:"deadline"`
+		Readers  map[string]time.Time `json:"readers,omitempty"`
+		Permits  map[string]time.Time `json:"permits,omitempty"`
+		Waiters  map[string]time.Time `json:"waiters,omitempty"`
+	}
+)
+
+//------------ holders ----------------
+
+//清理过期的持有者
+func (holders *mutexHolders) clean(now time.Time) {
+	if holders.Token != "" && now.After(holders.Deadline) {
+		holders.Token = ""
+		holders.Deadline = time.Time{}
+	}
+	for token, deadline := range holders.Readers {
+		if now.After(deadline) {
+			delete(holders.Readers, token)
+		}
+	}
+	for _, tokens := range []map[string]time.Time{holders.Permits, holders.Waiters} {
+		for token, deadline := range tokens {
+			if now.After(deadline) {
+				delete(tokens, token)
+			}
+		}
+	}
+}
+
+func (holders *mutexHolders) empty() bool {
+	return holders.Token == "" && len(holders.Readers) == 0 && len(holders.Permits) == 0 && len(holders.Waiters) == 0
+}
+
+//写锁，有写锁或读锁时失败
+func (holders *mutexHolders) lock(token string, deadline, now time.Time) error {
+	holders.clean(now)
+	if holders.Token != "" || len(holders.Readers) > 0 {
+		return ErrMutexLocked
+	}
+	holders.Token = token
+	holders.Deadline = deadline
+	return nil
+}
+
+func (holders *mutexHolders) unlock(token string) error {
+	if holders.Token == "" || holders.Token != token {
+		return ErrMutexToken
+	}
+	holders.Token = ""
+	holders.Deadline = time.Time{}
+	return nil
+}
+
+//读锁，有写锁或写锁在等待时失败
+func (holders *mutexHolders) rlock(token string, deadline, now time.Time) error {
+	holders.clean(now)
+	if holders.Token != "" || len(holders.Waiters) > 0 {
+		return ErrMutexLocked
+	}
+	if holders.Readers == nil {
+		holders.Readers = map[string]time.Time{}
+	}
+	holders.Readers[token] = deadline
+	return nil
+}
+
+func (holders *mutexHolders) runlock(token string) error {
+	if _, ok := holders.Readers[token]; !ok {
+		return ErrMutexToken
+	}
+	delete(holders.Readers, token)
+	return nil
+}
+
+//登记等待的写锁，重复登记只是续上截止时间
+func (holders *mutexHolders) wait(token string, deadline, now time.Time) error {
+	holders.clean(now)
+	if holders.Waiters == nil {
+		holders.Waiters = map[string]time.Time{}
+	}
+	holders.Waiters[token] = deadline
+	return nil
+}
+
+func (holders *mutexHolders) unwait(token string) error {
+	if _, ok := holders.Waiters[token]; !ok {
+		return ErrMutexToken
+	}
+	delete(holders.Waiters, token)
+	return nil
+}
+
+//信号量，满了时失败
+func (holders *mutexHolders) acquire(token string, limit int, deadline, now time.Time) error {
+	holders.clean(now)
+	if len(holders.Permits) >= limit {
+		return ErrMutexLocked
+	}
+	if holders.Permits == nil {
+		holders.Permits = map[string]time.Time{}
+	}
+	holders.Permits[token] = deadline
+	return nil
+}
+
+func (holders *mutexHolders) release(token string) error {
+	if _, ok := holders.Permits[token]; !ok {
+		return ErrMutexToken
+	}
+	delete(holders.Permits, token)
+	return nil
+}
+
+//续期，已过期的不能续期
+func (holders *mutexHolders) extend(token string, deadline, now time.Time) error {
+	if holders.Token != "" && holders.Token == token {
+		if now.After(holders.Deadline) {
+			return ErrMutexToken
+		}
+		holders.Deadline = deadline
+		return nil
+	}
+	for _, tokens := range []map[string]time.Time{holders.Readers, holders.Permits} {
+		if old, ok := tokens[token]; ok {
+			if now.After(old) {
+				return ErrMutexToken
+			}
+			tokens[token] = deadline
+			return nil
+		}
+	}
+	return ErrMutexToken
+}
+
+//------------ connect ----------------
+
+func (driver *memoryMutexDriver) Connect(name string, config MutexConfig) (MutexConnect, error) {
+	return &memoryMutexConnect{
+		name: name, config: config,
+		holders: map[string]*mutexHolders{},
+	}, nil
+}
+
+//打开连接，定时清理过期的锁
+func (connect *memoryMutexConnect) Open() error {
+	closing := make(chan bool)
+	connect.closing = closing
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closing:
+				return
+			case <-ticker.C:
+				connect.sweeping()
+			}
+		}
+	}()
+	return nil
+}
+
+func (connect *memoryMutexConnect) Health() (MutexHealth, error) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+	return MutexHealth{Workload: int64(len(connect.holders))}, nil
+}
+
+func (connect *memoryMutexConnect) Close() error {
+	if connect.closing != nil {
+		close(connect.closing)
+		connect.closing = nil
+	}
+	return nil
+}
+
+func (connect *memoryMutexConnect) sweeping() {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	now := time.Now()
+	for key, holders := range connect.holders {
+		holders.clean(now)
+		if holders.empty() {
+			delete(connect.holders, key)
+		}
+	}
+}
+
+//在持有者上操作，成功时按需返回新的fencing序号
+func (connect *memoryMutexConnect) holding(key string, fencing bool, call func(*mutexHolders, time.Time) error) (int64, error) {
+	connect.mutex.Lock()
+	defer connect.mutex.Unlock()
+
+	holders, ok := connect.holders[key]
+	if !ok {
+		holders = &mutexHolders{}
+		connect.holders[key] = holders
+	}
+
+	err := call(holders, time.Now())
+	if holders.empty() {
+		delete(connect.holders, key)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if fencing {
+		connect.fence++
+		return connect.fence, nil
+	}
+	return 0, nil
+}
+
+func (connect *memoryMutexConnect) Lock(key, token string, expiry time.Duration) (int64, error) {
+	return connect.holding(key, true, func(holders *mutexHolders, now time.Time) error {
+		return holders.lock(token, now.Add(expiry), now)
+	})
+}
+
+func (connect *memoryMutexConnect) Unlock(key, token string) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.unlock(token)
+	})
+	return err
+}
+
+func (connect *memoryMutexConnect) Extend(key, token string, expiry time.Duration) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.extend(token, now.Add(expiry), now)
+	})
+	return err
+}
+
+func (connect *memoryMutexConnect) RLock(key, token string, expiry time.Duration) (int64, error) {
+	return connect.holding(key, true, func(holders *mutexHolders, now time.Time) error {
+		return holders.rlock(token, now.Add(expiry), now)
+	})
+}
+
+func (connect *memoryMutexConnect) RUnlock(key, token string) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.runlock(token)
+	})
+	return err
+}
+
+func (connect *memoryMutexConnect) Wait(key, token string, expiry time.Duration) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.wait(token, now.Add(expiry), now)
+	})
+	return err
+}
+
+func (connect *memoryMutexConnect) Unwait(key, token string) error {
+	_, err := connect.holding(key, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.unwait(token)
+	})
+	return err
+}
+
+func (connect *memoryMutexConnect) Acquire(name, token string, limit int, expiry time.Duration) (int64, error) {
+	return connect.holding(name, true, func(holders *mutexHolders, now time.Time) error {
+		return holders.acquire(token, limit, now.Add(expiry), now)
+	})
+}
+
+func (connect *memoryMutexConnect) Release(name, token string) error {
+	_, err := connect.holding(name, false, func(holders *mutexHolders, now time.Time) error {
+		return holders.release(token)
+	})
+	return err
+}
@@ -0,0 +1,321 @@
+package ark
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/arkgo/asset"
+)
+
+//所有互斥驱动都要通过的测试，内存和文件驱动各跑一遍
+
+func mutexConnecting(t *testing.T, driver MutexDriver, setting Map) MutexConnect {
+	connect, err := driver.Connect("test", MutexConfig{Setting: setting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := connect.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connect.Close() })
+	return connect
+}
+
+func mutexDrivers(t *testing.T, call func(*testing.T, MutexConnect)) {
+	t.Run("memory", func(t *testing.T) {
+		call(t, mutexConnecting(t, &memoryMutexDriver{}, Map{}))
+	})
+	t.Run("file", func(t *testing.T) {
+		call(t, mutexConnecting(t, &fileMutexDriver{}, Map{"path": t.TempDir()}))
+	})
+}
+
+func TestMutexLock(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		fence1, err := connect.Lock("key", "a", time.Minute)
+		if err != nil {
+			t.Fatalf("lock: %v", err)
+		}
+		//已被持有时立即失败，不等待
+		if _, err := connect.Lock("key", "b", time.Minute); err != ErrMutexLocked {
+			t.Fatalf("try lock: got %v, want ErrMutexLocked", err)
+		}
+		//token不匹配不能解锁
+		if err := connect.Unlock("key", "b"); err != ErrMutexToken {
+			t.Fatalf("unlock other: got %v, want ErrMutexToken", err)
+		}
+		if err := connect.Unlock("key", "a"); err != nil {
+			t.Fatalf("unlock: %v", err)
+		}
+		if err := connect.Unlock("key", "a"); err != ErrMutexToken {
+			t.Fatalf("unlock twice: got %v, want ErrMutexToken", err)
+		}
+
+		fence2, err := connect.Lock("key", "b", time.Minute)
+		if err != nil {
+			t.Fatalf("relock: %v", err)
+		}
+		if fence2 <= fence1 {
+			t.Fatalf("fence not increasing: %d then %d", fence1, fence2)
+		}
+		//不同的key互不影响
+		if _, err := connect.Lock("other", "c", time.Minute); err != nil {
+			t.Fatalf("lock other key: %v", err)
+		}
+	})
+}
+
+func TestMutexLockConcurrent(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		holders := 0
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				if _, err := connect.Lock("key", token, time.Minute); err == nil {
+					mutex.Lock()
+					holders++
+					mutex.Unlock()
+				}
+			}(mutexToken())
+		}
+		wg.Wait()
+		if holders != 1 {
+			t.Fatalf("got %d holders, want 1", holders)
+		}
+	})
+}
+
+func TestMutexExpiry(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		if _, err := connect.Lock("key", "a", 50*time.Millisecond); err != nil {
+			t.Fatalf("lock: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		//过期后别人可以加锁，原持有者不能续期也不能解锁
+		if err := connect.Extend("key", "a", time.Minute); err != ErrMutexToken {
+			t.Fatalf("extend expired: got %v, want ErrMutexToken", err)
+		}
+		if _, err := connect.Lock("key", "b", time.Minute); err != nil {
+			t.Fatalf("lock after expiry: %v", err)
+		}
+		if err := connect.Unlock("key", "a"); err != ErrMutexToken {
+			t.Fatalf("unlock expired: got %v, want ErrMutexToken", err)
+		}
+	})
+}
+
+func TestMutexExtend(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		if _, err := connect.Lock("key", "a", 100*time.Millisecond); err != nil {
+			t.Fatalf("lock: %v", err)
+		}
+		if err := connect.Extend("key", "b", time.Minute); err != ErrMutexToken {
+			t.Fatalf("extend other: got %v, want ErrMutexToken", err)
+		}
+		if err := connect.Extend("key", "a", time.Minute); err != nil {
+			t.Fatalf("extend: %v", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+
+		//续期后超过原来的过期时间也还持有
+		if _, err := connect.Lock("key", "b", time.Minute); err != ErrMutexLocked {
+			t.Fatalf("lock after extend: got %v, want ErrMutexLocked", err)
+		}
+		if err := connect.Unlock("key", "a"); err != nil {
+			t.Fatalf("unlock: %v", err)
+		}
+	})
+}
+
+func TestMutexShared(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		shared, ok := connect.(MutexShared)
+		if !ok {
+			t.Skip("driver does not support shared locks")
+		}
+
+		//读锁可以同时持有多个，和写锁互斥
+		if _, err := shared.RLock("key", "r1", time.Minute); err != nil {
+			t.Fatalf("rlock: %v", err)
+		}
+		if _, err := shared.RLock("key", "r2", time.Minute); err != nil {
+			t.Fatalf("second rlock: %v", err)
+		}
+		if _, err := connect.Lock("key", "w", time.Minute); err != ErrMutexLocked {
+			t.Fatalf("lock with readers: got %v, want ErrMutexLocked", err)
+		}
+		if err := shared.RUnlock("key", "w"); err != ErrMutexToken {
+			t.Fatalf("runlock other: got %v, want ErrMutexToken", err)
+		}
+		if err := shared.RUnlock("key", "r1"); err != nil {
+			t.Fatalf("runlock: %v", err)
+		}
+		if err := shared.RUnlock("key", "r2"); err != nil {
+			t.Fatalf("runlock: %v", err)
+		}
+
+		if _, err := connect.Lock("key", "w", time.Minute); err != nil {
+			t.Fatalf("lock without readers: %v", err)
+		}
+		if _, err := shared.RLock("key", "r3", time.Minute); err != ErrMutexLocked {
+			t.Fatalf("rlock with writer: got %v, want ErrMutexLocked", err)
+		}
+	})
+}
+
+func TestMutexWriterPreference(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		shared, ok := connect.(MutexShared)
+		waiter, ok2 := connect.(MutexWaiter)
+		if !ok || !ok2 {
+			t.Skip("driver does not support shared locks")
+		}
+
+		if _, err := shared.RLock("key", "r1", time.Minute); err != nil {
+			t.Fatalf("rlock: %v", err)
+		}
+		//只是试一下的写锁不挡读锁
+		if _, err := connect.Lock("key", "w", time.Minute); err != ErrMutexLocked {
+			t.Fatalf("lock with readers: got %v, want ErrMutexLocked", err)
+		}
+		if _, err := shared.RLock("key", "r2", time.Minute); err != nil {
+			t.Fatalf("rlock after failed lock: %v", err)
+		}
+		//登记了等待的写锁，新的读锁要让路
+		if err := waiter.Wait("key", "w", time.Minute); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+		if _, err := shared.RLock("key", "r3", time.Minute); err != ErrMutexLocked {
+			t.Fatalf("rlock with waiting writer: got %v, want ErrMutexLocked", err)
+		}
+		shared.RUnlock("key", "r1")
+		shared.RUnlock("key", "r2")
+		if _, err := connect.Lock("key", "w", time.Minute); err != nil {
+			t.Fatalf("lock after readers left: %v", err)
+		}
+		if err := waiter.Unwait("key", "w"); err != nil {
+			t.Fatalf("unwait: %v", err)
+		}
+		if err := connect.Unlock("key", "w"); err != nil {
+			t.Fatalf("unlock: %v", err)
+		}
+		if _, err := shared.RLock("key", "r4", time.Minute); err != nil {
+			t.Fatalf("rlock after writer: %v", err)
+		}
+	})
+}
+
+func TestMutexWriterWaitingExpires(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		shared, ok := connect.(MutexShared)
+		waiter, ok2 := connect.(MutexWaiter)
+		if !ok || !ok2 {
+			t.Skip("driver does not support shared locks")
+		}
+
+		if _, err := shared.RLock("key", "r1", time.Minute); err != nil {
+			t.Fatalf("rlock: %v", err)
+		}
+		if err := waiter.Wait("key", "w", 50*time.Millisecond); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+		//等待的进程没了，登记过期后读锁又可以加了
+		time.Sleep(100 * time.Millisecond)
+		if _, err := shared.RLock("key", "r2", time.Minute); err != nil {
+			t.Fatalf("rlock after writer gave up: %v", err)
+		}
+		if err := waiter.Unwait("key", "w"); err != ErrMutexToken {
+			t.Fatalf("unwait expired: got %v, want ErrMutexToken", err)
+		}
+	})
+}
+
+func TestMutexSemaphore(t *testing.T) {
+	mutexDrivers(t, func(t *testing.T, connect MutexConnect) {
+		semaphore, ok := connect.(MutexSemaphore)
+		if !ok {
+			t.Skip("driver does not support semaphores")
+		}
+
+		for _, token := range []string{"a", "b"} {
+			if _, err := semaphore.Acquire("pool", token, 2, time.Minute); err != nil {
+				t.Fatalf("acquire %s: %v", token, err)
+			}
+		}
+		if _, err := semaphore.Acquire("pool", "c", 2, time.Minute); err != ErrMutexLocked {
+			t.Fatalf("acquire full: got %v, want ErrMutexLocked", err)
+		}
+		if err := semaphore.Release("pool", "c"); err != ErrMutexToken {
+			t.Fatalf("release other: got %v, want ErrMutexToken", err)
+		}
+		if err := semaphore.Release("pool", "a"); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if _, err := semaphore.Acquire("pool", "c", 2, time.Minute); err != nil {
+			t.Fatalf("acquire after release: %v", err)
+		}
+
+		//许可可以续期，过期后会被回收
+		if _, err := semaphore.Acquire("short", "a", 1, 50*time.Millisecond); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if err := connect.Extend("short", "a", 50*time.Millisecond); err != nil {
+			t.Fatalf("extend permit: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, err := semaphore.Acquire("short", "b", 1, time.Minute); err != nil {
+			t.Fatalf("acquire after expiry: %v", err)
+		}
+	})
+}
+
+func TestMutexFileShared(t *testing.T) {
+	//同一个目录的两个连接，相当于两个进程
+	path := t.TempDir()
+	one := mutexConnecting(t, &fileMutexDriver{}, Map{"path": path})
+	two := mutexConnecting(t, &fileMutexDriver{}, Map{"path": path})
+
+	fence1, err := one.Lock("key", "a", time.Minute)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if _, err := two.Lock("key", "b", time.Minute); err != ErrMutexLocked {
+		t.Fatalf("lock from other connect: got %v, want ErrMutexLocked", err)
+	}
+	if err := one.Unlock("key", "a"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	fence2, err := two.Lock("key", "b", time.Minute)
+	if err != nil {
+		t.Fatalf("lock from other connect: %v", err)
+	}
+	if fence2 <= fence1 {
+		t.Fatalf("fence not shared: %d then %d", fence1, fence2)
+	}
+}
+
+func TestMutexFileFencingFailed(t *testing.T) {
+	path := t.TempDir()
+	connect := mutexConnecting(t, &fileMutexDriver{}, Map{"path": path})
+
+	//拿不到fencing序号时加锁失败，也不能留下持有记录
+	fence := filepath.Join(path, fileMutexFence)
+	if err := os.Mkdir(fence, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connect.Lock("key", "a", time.Minute); err == nil {
+		t.Fatal("lock succeeded without a fencing number")
+	}
+	os.Remove(fence)
+
+	if _, err := connect.Lock("key", "b", time.Minute); err != nil {
+		t.Fatalf("lock after fencing failed: %v", err)
+	}
+}
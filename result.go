@@ -1,15 +1,29 @@
 package ark
 
 import (
+	"errors"
+	"fmt"
+
 	. "github.com/arkgo/asset"
 )
 
 type (
-//arkResult struct {
-//	code  int
-//	error string
-//	args  []Any
-//}
+	//arkResult struct {
+	//	code  int
+	//	error string
+	//	args  []Any
+	//}
+
+	//结果附带的底层错误，放在Args的最后，格式化文字的时候会被过滤掉
+	resCause struct {
+		err error
+	}
+
+	// ResError 把结果包装成error，支持errors.Is/As/Unwrap
+	ResError struct {
+		Res   *Res
+		cause error
+	}
 )
 
 var (
@@ -23,7 +37,19 @@ func codeResult(code int, error string, args ...Any) *Res {
 	return &Res{code, error, args}
 }
 func errResult(err error) *Res {
-	return &Res{-1, err.Error(), []Any{}}
+	return &Res{-1, err.Error(), []Any{resCause{err}}}
+}
+
+//去掉参数中的底层错误
+func uncaused(args []Any) []Any {
+	for i, arg := range args {
+		if _, ok := arg.(resCause); ok {
+			clean := make([]Any, 0, len(args)-1)
+			clean = append(clean, args[:i]...)
+			return append(clean, uncaused(args[i+1:])...)
+		}
+	}
+	return args
 }
 
 func Result(code int, state string, text string, overrides ...bool) *Res {
@@ -34,6 +60,81 @@ func Result(code int, state string, text string, overrides ...bool) *Res {
 	return codeResult(code, state) //结束不包括使用的文字，需要文字的时候走basic.String方法拿
 }
 
+// ResultStatus 设置结果对应的HTTP状态
+func ResultStatus(res *Res, status int) *Res {
+	if res != nil {
+		ark.Basic.StateStatus(res.Text, status)
+	}
+	return res
+}
+
+// Wrap 给结果附加底层错误，客户端看到的还是稳定的状态码，日志可以拿到原因
+// 返回的是新的结果，不会修改原来的结果，比如 Fail 这样的全局变量
+func Wrap(res *Res, err error) *Res {
+	if err == nil {
+		return res
+	}
+	if res == nil {
+		res = Fail
+	}
+	args := []Any{}
+	args = append(args, res.Args...)
+	args = append(args, resCause{err})
+	return &Res{res.Code, res.Text, args}
+}
+
+// Cause 获取结果附带的底层错误
+func Cause(res *Res) error {
+	if res == nil {
+		return nil
+	}
+	for i := len(res.Args) - 1; i >= 0; i-- {
+		if cause, ok := res.Args[i].(resCause); ok {
+			return cause.err
+		}
+	}
+	return nil
+}
+
+// Errorize 把结果转成error，成功的结果返回nil
+func Errorize(res *Res) error {
+	if res.OK() {
+		return nil
+	}
+	return &ResError{res, Cause(res)}
+}
+
+// Resultize 从error中取出结果，支持被fmt.Errorf("%w")包装过的
+func Resultize(err error) *Res {
+	if err == nil {
+		return nil
+	}
+	var resErr *ResError
+	if errors.As(err, &resErr) {
+		return resErr.Res
+	}
+	return errResult(err)
+}
+
+func (err *ResError) Error() string {
+	text := ark.Basic.String(DEFAULT, err.Res.Text, uncaused(err.Res.Args)...)
+	if err.cause != nil {
+		return fmt.Sprintf("%s(%d): %v", text, ark.Basic.Code(err.Res.Text, err.Res.Code), err.cause)
+	}
+	return fmt.Sprintf("%s(%d)", text, ark.Basic.Code(err.Res.Text, err.Res.Code))
+}
+func (err *ResError) Unwrap() error {
+	return err.cause
+}
+
+// Is 状态相同就认为是同一个错误，比如 errors.Is(err, Errorize(Fail))
+func (err *ResError) Is(target error) bool {
+	if tt, ok := target.(*ResError); ok && tt.Res != nil && err.Res != nil {
+		return tt.Res.Text == err.Res.Text
+	}
+	return false
+}
+
 //func (res *arkResult) Code() int {
 //	if res == nil {
 //		return 0
@@ -361,6 +361,10 @@ func (module *serviceModule) Arguments(name string, extends ...Vars) Vars {
 func (lib *library) Name() string {
 	return lib.name
 }
+//Logger 库的日志，名称就是库名，级别在 [logger.levels] 中配置
+func (lib *library) Logger() *Logging {
+	return ark.Logger.Logger(lib.name)
+}
 func (lib *library) Register(name string, config Method, overrides ...bool) {
 	realName := fmt.Sprintf("%s.%s", lib.name, name)
 	lib.module.Method(realName, config, overrides...)
@@ -381,6 +385,14 @@ func (lgc *Program) Data(bases ...string) DataBase {
 	return lgc.dataBase(bases...)
 }
 
+// Message 当前处理的总线消息，不是由事件或队列触发的返回空的
+func (lgc *Program) Message() Message {
+	if lgc.context == nil || lgc.message == nil {
+		return Message{}
+	}
+	return *lgc.message
+}
+
 // func (service *Program) Invoke(name string, values ...Map) Map {
 // 	value := Map{}
 // 	if len(values) > 0 {
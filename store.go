@@ -331,7 +331,7 @@ func (module *storeModule) thumbnail(code string, w, h, t int64) (string, File,
 	//先获取缩略图的文件
 	_, _, tfile, err := module.thumbnailing(data, w, h, t)
 	if err != nil {
-		Debug("生成缩图获取保存位置", err, code)
+		ark.Logger.Logger("store").Debug("生成缩图获取保存位置", "error", err, "code", code)
 		return "", nil, nil
 	}
 
@@ -350,7 +350,7 @@ func (module *storeModule) thumbnail(code string, w, h, t int64) (string, File,
 		}
 		fff, err := conn.Download(data)
 		if err != nil {
-			Warning("生成缩图下载文件", err, code)
+			ark.Logger.Logger("store").Warning("生成缩图下载文件", "error", err, "code", code)
 			return "", nil, err
 		} else {
 			sfile = fff
@@ -359,7 +359,7 @@ func (module *storeModule) thumbnail(code string, w, h, t int64) (string, File,
 		//获取存储的文件
 		_, _, fff, err := module.storaging(data)
 		if err != nil {
-			Warning("生成缩图获取文件", err, code)
+			ark.Logger.Logger("store").Warning("生成缩图获取文件", "error", err, "code", code)
 			return "", nil, err
 		} else {
 			sfile = fff
@@ -368,14 +368,14 @@ func (module *storeModule) thumbnail(code string, w, h, t int64) (string, File,
 
 	sf, err := os.Open(sfile)
 	if err != nil {
-		Warning("生成缩图打开文件", err, code)
+		ark.Logger.Logger("store").Warning("生成缩图打开文件", "error", err, "code", code)
 		return "", nil, err
 	}
 	defer sf.Close()
 
 	cfg, err := util.DecodeImageConfig(sf)
 	if err != nil {
-		Warning("生成缩图解析图片配置", err, code)
+		ark.Logger.Logger("store").Warning("生成缩图解析图片配置", "error", err, "code", code)
 		return "", nil, err
 	}
 
@@ -383,7 +383,7 @@ func (module *storeModule) thumbnail(code string, w, h, t int64) (string, File,
 	sf.Seek(0, 0)
 	img, err := imaging.Decode(sf)
 	if err != nil {
-		Warning("生成缩图解析图片", err, code)
+		ark.Logger.Logger("store").Warning("生成缩图解析图片", "error", err, "code", code)
 		return "", nil, err
 	}
 
@@ -399,7 +399,7 @@ func (module *storeModule) thumbnail(code string, w, h, t int64) (string, File,
 	thumb := imaging.Thumbnail(img, int(width), int(height), imaging.NearestNeighbor)
 	err = imaging.Save(thumb, tfile)
 	if err != nil {
-		Warning("生成缩图保存文件", err, code)
+		ark.Logger.Logger("store").Warning("生成缩图保存文件", "error", err, "code", code)
 		return "", nil, err
 	}
 